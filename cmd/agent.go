@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/rancher/support-bundle-kit/pkg/agent"
+	"github.com/rancher/support-bundle-kit/pkg/utils"
+)
+
+var (
+	sba = &agent.SupportBundleAgent{}
+)
+
+// agentCmd represents the agent command
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Support Bundle Kit agent",
+	Long: `Support Bundle Kit agent
+
+The agent runs on each node and collects the node bundle with the collector
+spec of the host OS. The bundle is uploaded to the support bundle manager.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// the token is only read from the environment, so it doesn't show up
+		// in the process list or the help
+		sba.Token = os.Getenv("SUPPORT_BUNDLE_AGENT_TOKEN")
+		if err := sba.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+	agentCmd.PersistentFlags().StringVar(&sba.ManagerURL, "manager-url", os.Getenv("SUPPORT_BUNDLE_MANAGER_URL"), "The URL of the support bundle manager")
+	agentCmd.PersistentFlags().StringVar(&sba.NodeName, "node-name", os.Getenv("SUPPORT_BUNDLE_NODE_NAME"), "The node name (default is the hostname of the host)")
+	agentCmd.PersistentFlags().StringVar(&sba.HostPath, "host-path", os.Getenv("SUPPORT_BUNDLE_HOST_PATH"), "The path the host root is mounted at (default is /)")
+	agentCmd.PersistentFlags().StringVar(&sba.OutputDir, "outdir", os.Getenv("SUPPORT_BUNDLE_CACHE_PATH"), "The directory to store the node bundle")
+	agentCmd.PersistentFlags().StringVar(&sba.CollectorsDir, "collectors-dir", os.Getenv("SUPPORT_BUNDLE_COLLECTORS_DIR"), "The directory of collector specs (default is /etc/support-bundle-kit/collectors)")
+	agentCmd.PersistentFlags().StringVar(&sba.ManagerCA, "manager-ca", os.Getenv("SUPPORT_BUNDLE_MANAGER_CA"), "PEM-encoded CA bundle to verify the manager's certificate with")
+	agentCmd.PersistentFlags().StringVar(&sba.ExtraCollectors, "extra-collectors", os.Getenv("SUPPORT_BUNDLE_EXTRA_COLLECTORS"), "List of collector specs to run in addition to the spec of the host OS delimited by ,")
+	agentCmd.PersistentFlags().IntVar(&sba.UploadRetries, "upload-retries", utils.EnvGetInt("SUPPORT_BUNDLE_UPLOAD_RETRIES", 8), "Number of retries of a failed upload")
+}
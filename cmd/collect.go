@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+
+	"github.com/rancher/support-bundle-kit/pkg/manager"
+	"github.com/rancher/support-bundle-kit/pkg/manager/client"
+	"github.com/rancher/support-bundle-kit/pkg/utils"
+)
+
+var (
+	collector = &manager.SupportBundleManager{Standalone: true}
+)
+
+// collectCmd represents the collect command
+var collectCmd = &cobra.Command{
+	Use:   "collect",
+	Short: "Collect a cluster bundle from outside the cluster",
+	Long: `Collect a cluster bundle from outside the cluster
+
+The collect command runs the cluster phase of the manager with a kubeconfig
+and writes the bundle to a local directory. It doesn't need the manager to be
+deployed in the cluster. Node bundles are not collected in this mode.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := collector.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("support bundle is written to %s\n", collector.GetBundlefile())
+	},
+}
+
+func defaultKubeConfig() string {
+	if kubeconfig := os.Getenv("KUBECONFIG"); kubeconfig != "" {
+		return kubeconfig
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".kube", "config")
+}
+
+func init() {
+	rootCmd.AddCommand(collectCmd)
+	collectCmd.PersistentFlags().StringVar(&collector.KubeConfig, "kubeconfig", defaultKubeConfig(), "Path to the kubeconfig file")
+	collectCmd.PersistentFlags().StringVar(&collector.Profiles, "profiles", os.Getenv("SUPPORT_BUNDLE_PROFILES"), fmt.Sprintf("List of collection profiles delimited by , (default is %s), available profiles: %s", manager.DefaultProfiles, strings.Join(manager.ProfileNames(), ", ")))
+	collectCmd.PersistentFlags().StringVar(&collector.NamespaceList, "namespaces", os.Getenv("SUPPORT_BUNDLE_TARGET_NAMESPACES"), "List of namespaces, glob patterns (tenant-*) and single label selectors (env=prod) delimited by ,. Items prefixed with ! are excluded")
+	collectCmd.PersistentFlags().StringVar(&collector.NamespaceSelector, "namespace-selector", os.Getenv("SUPPORT_BUNDLE_NAMESPACE_SELECTOR"), "Label selector of namespaces to collect, e.g., env=prod,tier=web selects namespaces with both labels")
+	collectCmd.PersistentFlags().BoolVar(&collector.AllNamespaces, "all-namespaces", utils.EnvGetBool("SUPPORT_BUNDLE_ALL_NAMESPACES", false), "Collect all namespaces except the excluded ones")
+	collectCmd.PersistentFlags().StringVar(&collector.IncludeResources, "include-resources", os.Getenv("SUPPORT_BUNDLE_INCLUDE_RESOURCES"), "List of resources to collect delimited by , e.g., *.cattle.io/*,core/v1/pods (default is all resources)")
+	collectCmd.PersistentFlags().StringVar(&collector.ExcludeResources, "exclude-resources", os.Getenv("SUPPORT_BUNDLE_EXCLUDE_RESOURCES"), "List of resources to skip delimited by , e.g., events,leases")
+	collectCmd.PersistentFlags().IntVar(&collector.FetchWorkers, "fetch-workers", utils.EnvGetInt("SUPPORT_BUNDLE_FETCH_WORKERS", client.DefaultFetchWorkers), "Number of concurrent requests fetching resources, the default is used if it's not positive")
+	collectCmd.PersistentFlags().IntVar(&collector.EncodeWorkers, "encode-workers", utils.EnvGetInt("SUPPORT_BUNDLE_ENCODE_WORKERS", client.DefaultEncodeWorkers), "Number of workers writing fetched resources to the bundle, the default is used if it's not positive")
+	collectCmd.PersistentFlags().IntVar(&collector.ClusterWideListThreshold, "cluster-wide-list-threshold", utils.EnvGetInt("SUPPORT_BUNDLE_CLUSTER_WIDE_LIST_THRESHOLD", client.DefaultClusterWideListThreshold), "Number of namespaces from which each resource is listed once in all namespaces, 0 disables it")
+	collectCmd.PersistentFlags().Int64Var(&collector.PageSize, "page-size", int64(utils.EnvGetInt("SUPPORT_BUNDLE_PAGE_SIZE", client.DefaultPageSize)), "Number of objects fetched per list request, 0 fetches lists at once")
+	collectCmd.PersistentFlags().StringVar(&collector.BundleName, "bundlename", "standalone", "The support bundle name")
+	collectCmd.PersistentFlags().StringVar(&collector.OutputDir, "outdir", ".", "The directory to store the bundle")
+	collectCmd.PersistentFlags().StringVar(&collector.OutputFormat, "output-format", os.Getenv("SUPPORT_BUNDLE_OUTPUT_FORMAT"), "Format of the bundle file: zip (default), tar.gz or tar.zst")
+	collectCmd.PersistentFlags().StringVar(&collector.RedactSecrets, "redact-secrets", os.Getenv("SUPPORT_BUNDLE_REDACT_SECRETS"), "How values of Secrets are redacted: hash (default), mask or none")
+	collectCmd.PersistentFlags().StringVar(&collector.RedactionRules, "redaction-rules", os.Getenv("SUPPORT_BUNDLE_REDACTION_RULES"), "Path to a file of redaction rules applied to logs and resources")
+	collectCmd.PersistentFlags().BoolVar(&collector.Anonymize, "anonymize", utils.EnvGetBool("SUPPORT_BUNDLE_ANONYMIZE", false), "Replace hostnames, IP addresses and usernames with stable tokens")
+	collectCmd.PersistentFlags().StringVar(&collector.AnonymizeUsers, "anonymize-users", os.Getenv("SUPPORT_BUNDLE_ANONYMIZE_USERS"), "List of additional usernames to anonymize delimited by ,")
+}
@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"github.com/rancher/support-bundle-kit/pkg/manager"
+)
+
+// configBinding binds a flag to a key of the configuration file and to an
+// environment variable
+type configBinding struct {
+	flag string
+	key  string
+	env  string
+}
+
+var managerConfigBindings = []configBinding{
+	{"profiles", "profiles", "SUPPORT_BUNDLE_PROFILES"},
+	{"namespaces", "namespaces", "SUPPORT_BUNDLE_TARGET_NAMESPACES"},
+	{"namespace-selector", "namespaceSelector", "SUPPORT_BUNDLE_NAMESPACE_SELECTOR"},
+	{"all-namespaces", "allNamespaces", "SUPPORT_BUNDLE_ALL_NAMESPACES"},
+	{"outdir", "outputDir", "SUPPORT_BUNDLE_OUTPUT_DIR"},
+	{"output-format", "outputFormat", "SUPPORT_BUNDLE_OUTPUT_FORMAT"},
+	{"image-name", "image.name", "SUPPORT_BUNDLE_IMAGE"},
+	{"image-pull-policy", "image.pullPolicy", "SUPPORT_BUNDLE_IMAGE_PULL_POLICY"},
+	{"node-selector", "collectors.nodeSelector", "SUPPORT_BUNDLE_NODE_SELECTOR"},
+	{"node-names", "collectors.nodeNames", "SUPPORT_BUNDLE_NODE_NAMES"},
+	{"skip-not-ready-nodes", "collectors.skipNotReadyNodes", "SUPPORT_BUNDLE_SKIP_NOT_READY_NODES"},
+	{"new-nodes", "collectors.newNodes", "SUPPORT_BUNDLE_NEW_NODES"},
+	{"kubelet-fallback", "collectors.kubeletFallback", "SUPPORT_BUNDLE_KUBELET_FALLBACK"},
+	{"include-resources", "resources.include", "SUPPORT_BUNDLE_INCLUDE_RESOURCES"},
+	{"exclude-resources", "resources.exclude", "SUPPORT_BUNDLE_EXCLUDE_RESOURCES"},
+	{"redact-secrets", "redaction.secrets", "SUPPORT_BUNDLE_REDACT_SECRETS"},
+	{"redaction-rules", "redaction.rules", "SUPPORT_BUNDLE_REDACTION_RULES"},
+	{"anonymize", "redaction.anonymize", "SUPPORT_BUNDLE_ANONYMIZE"},
+	{"anonymize-users", "redaction.anonymizeUsers", "SUPPORT_BUNDLE_ANONYMIZE_USERS"},
+	{"wait-timeout", "limits.waitTimeout", "SUPPORT_BUNDLE_WAIT_TIMEOUT"},
+	{"max-node-bundle-size", "limits.maxNodeBundleSize", "SUPPORT_BUNDLE_MAX_NODE_BUNDLE_SIZE"},
+	{"agent-restart-limit", "limits.agentRestartLimit", "SUPPORT_BUNDLE_AGENT_RESTART_LIMIT"},
+	{"fetch-workers", "limits.fetchWorkers", "SUPPORT_BUNDLE_FETCH_WORKERS"},
+	{"encode-workers", "limits.encodeWorkers", "SUPPORT_BUNDLE_ENCODE_WORKERS"},
+	{"page-size", "limits.pageSize", "SUPPORT_BUNDLE_PAGE_SIZE"},
+	{"cluster-wide-list-threshold", "limits.clusterWideListThreshold", "SUPPORT_BUNDLE_CLUSTER_WIDE_LIST_THRESHOLD"},
+	{"auth", "auth.enabled", "SUPPORT_BUNDLE_AUTH"},
+	{"auth-verb", "auth.verb", "SUPPORT_BUNDLE_AUTH_VERB"},
+	{"auth-resource", "auth.resource", "SUPPORT_BUNDLE_AUTH_RESOURCE"},
+	{"auth-group", "auth.group", "SUPPORT_BUNDLE_AUTH_GROUP"},
+	{"tls", "tls.enabled", "SUPPORT_BUNDLE_TLS"},
+	{"tls-cert", "tls.cert", "SUPPORT_BUNDLE_TLS_CERT"},
+	{"tls-key", "tls.key", "SUPPORT_BUNDLE_TLS_KEY"},
+}
+
+// loadManagerConfig applies the configuration file to the manager. Flags set on
+// the command line take precedence over environment variables, which take
+// precedence over the configuration file.
+func loadManagerConfig(cmd *cobra.Command) error {
+	v := viper.GetViper()
+	for _, b := range managerConfigBindings {
+		flag := cmd.Flags().Lookup(b.flag)
+		if flag == nil {
+			return fmt.Errorf("BUG: flag %s is not found", b.flag)
+		}
+		if err := v.BindPFlag(b.key, flag); err != nil {
+			return err
+		}
+		if err := v.BindEnv(b.key, b.env); err != nil {
+			return err
+		}
+		// the defaults of the flags silently ignore invalid environment
+		// variables
+		if value := os.Getenv(b.env); value != "" && !flag.Changed {
+			if err := flag.Value.Set(value); err != nil {
+				return fmt.Errorf("invalid environment variable %s: %v", b.env, err)
+			}
+		}
+	}
+
+	if v.ConfigFileUsed() == "" {
+		return nil
+	}
+
+	// the file is decoded on its own, so its errors are not mixed up with
+	// the ones of flags and environment variables
+	file := viper.New()
+	file.SetConfigFile(v.ConfigFileUsed())
+	if err := file.ReadInConfig(); err != nil {
+		return fmt.Errorf("fail to read config file: %v", err)
+	}
+	config := &manager.Config{}
+	if err := file.UnmarshalExact(config); err != nil {
+		return fmt.Errorf("invalid config file %s: %v", v.ConfigFileUsed(), decodeError(err))
+	}
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid config file %s: %v", v.ConfigFileUsed(), err)
+	}
+
+	for _, b := range managerConfigBindings {
+		flag := cmd.Flags().Lookup(b.flag)
+		if flag.Changed {
+			continue
+		}
+		if err := setFlag(flag, v.Get(b.key)); err != nil {
+			return fmt.Errorf("invalid %s in config file %s: %v", b.key, v.ConfigFileUsed(), err)
+		}
+	}
+
+	template, err := config.GetAgentTemplate()
+	if err != nil {
+		return err
+	}
+	sbm.AgentTemplate = template
+	sbm.UploadTargets = config.Upload.Targets
+	return nil
+}
+
+// invalidKeysRegexp matches the unknown keys error of mapstructure
+var invalidKeysRegexp = regexp.MustCompile(`^'([^']*)' has invalid keys: `)
+
+// decodeError lists the errors of a decoding on one line, each naming its key,
+// instead of the multi-line error of mapstructure
+func decodeError(err error) error {
+	wrapper, ok := err.(interface{ WrappedErrors() []error })
+	if !ok {
+		return err
+	}
+	var msgs []string
+	for _, e := range wrapper.WrappedErrors() {
+		msg := e.Error()
+		if match := invalidKeysRegexp.FindStringSubmatch(msg); match != nil {
+			prefix := "unknown keys: "
+			if match[1] != "" {
+				prefix = fmt.Sprintf("unknown keys in %s: ", match[1])
+			}
+			msg = prefix + msg[len(match[0]):]
+		}
+		msgs = append(msgs, msg)
+	}
+	sort.Strings(msgs)
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+// setFlag sets a flag to a value of viper. Lists are joined by , as the flags
+// take them.
+func setFlag(flag *pflag.Flag, value interface{}) error {
+	if list, ok := value.([]interface{}); ok {
+		value = strings.Join(cast.ToStringSlice(list), ",")
+	}
+	s, err := cast.ToStringE(value)
+	if err != nil {
+		return err
+	}
+	return flag.Value.Set(s)
+}
+
+// readConfig reads the configuration file. A missing file is only an error if
+// it's set explicitly.
+func readConfig() error {
+	err := viper.ReadInConfig()
+	if err == nil {
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
+		return nil
+	}
+	if _, ok := err.(viper.ConfigFileNotFoundError); ok && cfgFile == "" {
+		return nil
+	}
+	return fmt.Errorf("fail to read config file: %v", err)
+}
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// setupManagerConfig resets the manager flags and viper, and loads the
+// configuration from a file with content, environment variables and flags
+func setupManagerConfig(t *testing.T, content string, env map[string]string, flags map[string]string) error {
+	// merges the persistent flags into the flags of the command
+	if err := managerCmd.ParseFlags(nil); err != nil {
+		t.Fatal(err)
+	}
+	reset := func() {
+		viper.Reset()
+		for _, b := range managerConfigBindings {
+			flag := managerCmd.Flags().Lookup(b.flag)
+			_ = flag.Value.Set(flag.DefValue)
+			flag.Changed = false
+			os.Unsetenv(b.env)
+		}
+		sbm.AgentTemplate = nil
+		sbm.UploadTargets = nil
+	}
+	reset()
+	t.Cleanup(reset)
+
+	if content != "" {
+		dir, err := ioutil.TempDir("", "config")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		path := filepath.Join(dir, "config.yaml")
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		viper.SetConfigFile(path)
+		if err := viper.ReadInConfig(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+	}
+	for k, v := range flags {
+		if err := managerCmd.Flags().Set(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return loadManagerConfig(managerCmd)
+}
+
+func TestLoadManagerConfigPrecedence(t *testing.T) {
+	file := "version: v1\nlimits:\n  waitTimeout: 10m\n"
+	env := map[string]string{"SUPPORT_BUNDLE_WAIT_TIMEOUT": "20m"}
+	flags := map[string]string{"wait-timeout": "5m"}
+
+	tests := []struct {
+		name  string
+		file  string
+		env   map[string]string
+		flags map[string]string
+		want  time.Duration
+	}{
+		{name: "default", want: 30 * time.Minute},
+		{name: "file", file: file, want: 10 * time.Minute},
+		{name: "env without file", env: env, want: 20 * time.Minute},
+		{name: "env over file", file: file, env: env, want: 20 * time.Minute},
+		{name: "flag over env and file", file: file, env: env, flags: flags, want: 5 * time.Minute},
+		{name: "file doesn't set the key", file: "version: v1\n", want: 30 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := setupManagerConfig(t, tt.file, tt.env, tt.flags); err != nil {
+				t.Fatal(err)
+			}
+			if sbm.WaitTimeout != tt.want {
+				t.Errorf("wait timeout = %v, want %v", sbm.WaitTimeout, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadManagerConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		want []string
+	}{
+		{
+			name: "unknown key",
+			file: "version: v1\nwaitTimeout: 10m\n",
+			want: []string{"invalid config file", "unknown keys: waittimeout"},
+		},
+		{
+			name: "unknown nested key",
+			file: "version: v1\nlimits:\n  timeout: 10m\n",
+			want: []string{"invalid config file", "unknown keys in limits: timeout"},
+		},
+		{
+			name: "invalid value in file",
+			file: "version: v1\nlimits:\n  waitTimeout: bogus\n",
+			want: []string{"invalid config file", "limits.waitTimeout", `invalid duration "bogus"`},
+		},
+		{
+			name: "unsupported version",
+			file: "version: v2\n",
+			want: []string{"invalid config file", `unsupported config version "v2"`},
+		},
+		{
+			name: "invalid env without file",
+			env:  map[string]string{"SUPPORT_BUNDLE_WAIT_TIMEOUT": "bogus"},
+			want: []string{"invalid environment variable SUPPORT_BUNDLE_WAIT_TIMEOUT", `invalid duration "bogus"`},
+		},
+		{
+			name: "invalid env with file",
+			file: "version: v1\nlimits:\n  waitTimeout: 10m\n",
+			env:  map[string]string{"SUPPORT_BUNDLE_WAIT_TIMEOUT": "bogus"},
+			want: []string{"invalid environment variable SUPPORT_BUNDLE_WAIT_TIMEOUT"},
+		},
+		{
+			name: "invalid env bool",
+			env:  map[string]string{"SUPPORT_BUNDLE_AUTH": "maybe"},
+			want: []string{"invalid environment variable SUPPORT_BUNDLE_AUTH"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := setupManagerConfig(t, tt.file, tt.env, nil)
+			if err == nil {
+				t.Fatalf("loadManagerConfig() succeeded, want %v", tt.want)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("loadManagerConfig() = %v, want %q", err, want)
+				}
+			}
+			if strings.Contains(err.Error(), "error(s) decoding") {
+				t.Errorf("loadManagerConfig() = %v, the error of mapstructure is not rewritten", err)
+			}
+		})
+	}
+}
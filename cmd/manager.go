@@ -3,8 +3,12 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/rancher/support-bundle-kit/pkg/manager"
+	"github.com/rancher/support-bundle-kit/pkg/manager/client"
+	"github.com/rancher/support-bundle-kit/pkg/utils"
 	"github.com/spf13/cobra"
 )
 
@@ -25,6 +29,10 @@ The manager collects following items:
 And it also waits for reports from support bundle agents. The reports contain:
 - Logs of each node.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		if err := loadManagerConfig(cmd); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(1)
+		}
 		if err := sbm.Run(); err != nil {
 			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 			os.Exit(1)
@@ -34,11 +42,41 @@ And it also waits for reports from support bundle agents. The reports contain:
 
 func init() {
 	rootCmd.AddCommand(managerCmd)
-	managerCmd.PersistentFlags().StringVar(&sbm.NamespaceList, "namespaces", os.Getenv("SUPPORT_BUNDLE_TARGET_NAMESPACES"), "List of namespaces delimited by ,")
+	managerCmd.PersistentFlags().StringVar(&sbm.Profiles, "profiles", os.Getenv("SUPPORT_BUNDLE_PROFILES"), fmt.Sprintf("List of collection profiles delimited by , (default is %s), available profiles: %s", manager.DefaultProfiles, strings.Join(manager.ProfileNames(), ", ")))
+	managerCmd.PersistentFlags().StringVar(&sbm.NamespaceList, "namespaces", os.Getenv("SUPPORT_BUNDLE_TARGET_NAMESPACES"), "List of namespaces, glob patterns (tenant-*) and single label selectors (env=prod) delimited by ,. Items prefixed with ! are excluded")
+	managerCmd.PersistentFlags().StringVar(&sbm.NamespaceSelector, "namespace-selector", os.Getenv("SUPPORT_BUNDLE_NAMESPACE_SELECTOR"), "Label selector of namespaces to collect, e.g., env=prod,tier=web selects namespaces with both labels")
+	managerCmd.PersistentFlags().BoolVar(&sbm.AllNamespaces, "all-namespaces", utils.EnvGetBool("SUPPORT_BUNDLE_ALL_NAMESPACES", false), "Collect all namespaces except the excluded ones")
+	managerCmd.PersistentFlags().StringVar(&sbm.IncludeResources, "include-resources", os.Getenv("SUPPORT_BUNDLE_INCLUDE_RESOURCES"), "List of resources to collect delimited by , e.g., *.cattle.io/*,core/v1/pods (default is all resources)")
+	managerCmd.PersistentFlags().StringVar(&sbm.ExcludeResources, "exclude-resources", os.Getenv("SUPPORT_BUNDLE_EXCLUDE_RESOURCES"), "List of resources to skip delimited by , e.g., events,leases")
+	managerCmd.PersistentFlags().IntVar(&sbm.FetchWorkers, "fetch-workers", utils.EnvGetInt("SUPPORT_BUNDLE_FETCH_WORKERS", client.DefaultFetchWorkers), "Number of concurrent requests fetching resources, the default is used if it's not positive")
+	managerCmd.PersistentFlags().IntVar(&sbm.EncodeWorkers, "encode-workers", utils.EnvGetInt("SUPPORT_BUNDLE_ENCODE_WORKERS", client.DefaultEncodeWorkers), "Number of workers writing fetched resources to the bundle, the default is used if it's not positive")
+	managerCmd.PersistentFlags().IntVar(&sbm.ClusterWideListThreshold, "cluster-wide-list-threshold", utils.EnvGetInt("SUPPORT_BUNDLE_CLUSTER_WIDE_LIST_THRESHOLD", client.DefaultClusterWideListThreshold), "Number of namespaces from which each resource is listed once in all namespaces, 0 disables it")
+	managerCmd.PersistentFlags().Int64Var(&sbm.PageSize, "page-size", int64(utils.EnvGetInt("SUPPORT_BUNDLE_PAGE_SIZE", client.DefaultPageSize)), "Number of objects fetched per list request, 0 fetches lists at once")
 	managerCmd.PersistentFlags().StringVar(&sbm.BundleName, "bundlename", os.Getenv("SUPPORT_BUNDLE_NAME"), "The support bundle name")
 	managerCmd.PersistentFlags().StringVar(&sbm.OutputDir, "outdir", os.Getenv("SUPPORT_BUNDLE_OUTPUT_DIR"), "The directory to store the bundle")
+	managerCmd.PersistentFlags().StringVar(&sbm.OutputFormat, "output-format", os.Getenv("SUPPORT_BUNDLE_OUTPUT_FORMAT"), "Format of the bundle file: zip (default), tar.gz or tar.zst")
+	managerCmd.PersistentFlags().StringVar(&sbm.RedactSecrets, "redact-secrets", os.Getenv("SUPPORT_BUNDLE_REDACT_SECRETS"), "How values of Secrets are redacted: hash (default), mask or none")
+	managerCmd.PersistentFlags().StringVar(&sbm.RedactionRules, "redaction-rules", os.Getenv("SUPPORT_BUNDLE_REDACTION_RULES"), "Path to a file of redaction rules applied to logs and resources")
+	managerCmd.PersistentFlags().BoolVar(&sbm.Anonymize, "anonymize", utils.EnvGetBool("SUPPORT_BUNDLE_ANONYMIZE", false), "Replace hostnames, IP addresses and usernames with stable tokens")
+	managerCmd.PersistentFlags().StringVar(&sbm.AnonymizeUsers, "anonymize-users", os.Getenv("SUPPORT_BUNDLE_ANONYMIZE_USERS"), "List of additional usernames to anonymize delimited by ,")
 	managerCmd.PersistentFlags().StringVar(&sbm.ManagerPodIP, "manager-pod-ip", os.Getenv("SUPPORT_BUNDLE_MANAGER_POD_IP"), "The support bundle manager's IP (pod runs this app)")
 	managerCmd.PersistentFlags().StringVar(&sbm.ImageName, "image-name", os.Getenv("SUPPORT_BUNDLE_IMAGE"), "The support bundle image")
 	managerCmd.PersistentFlags().StringVar(&sbm.ImagePullPolicy, "image-pull-policy", os.Getenv("SUPPORT_BUNDLE_IMAGE_PULL_POLICY"), "Pull policy of the support bundle image")
+	managerCmd.PersistentFlags().DurationVar(&sbm.WaitTimeout, "wait-timeout", utils.EnvGetDuration("SUPPORT_BUNDLE_WAIT_TIMEOUT", 30*time.Minute), "Time to wait for node bundles before packaging a partial bundle")
+	managerCmd.PersistentFlags().StringVar(&sbm.MaxNodeBundleSize, "max-node-bundle-size", utils.EnvGetString("SUPPORT_BUNDLE_MAX_NODE_BUNDLE_SIZE", "1Gi"), "Max size of a node bundle upload")
+	managerCmd.PersistentFlags().BoolVar(&sbm.Auth, "auth", utils.EnvGetBool("SUPPORT_BUNDLE_AUTH", false), "Require a bearer token allowed by cluster RBAC to get the status and the bundle")
+	managerCmd.PersistentFlags().StringVar(&sbm.AuthVerb, "auth-verb", utils.EnvGetString("SUPPORT_BUNDLE_AUTH_VERB", "get"), "The verb a user must be allowed on the support bundle")
+	managerCmd.PersistentFlags().StringVar(&sbm.AuthResource, "auth-resource", utils.EnvGetString("SUPPORT_BUNDLE_AUTH_RESOURCE", "supportbundles"), "The resource a user must be allowed to access")
+	managerCmd.PersistentFlags().StringVar(&sbm.AuthGroup, "auth-group", utils.EnvGetString("SUPPORT_BUNDLE_AUTH_GROUP", "harvesterhci.io"), "The API group of the auth resource")
+	managerCmd.PersistentFlags().BoolVar(&sbm.TLS, "tls", utils.EnvGetBool("SUPPORT_BUNDLE_TLS", false), "Serve HTTPS with a self-signed certificate unless a certificate is provided")
+	managerCmd.PersistentFlags().StringVar(&sbm.TLSCertFile, "tls-cert", os.Getenv("SUPPORT_BUNDLE_TLS_CERT"), "Path to the TLS certificate of the manager, implies --tls")
+	managerCmd.PersistentFlags().StringVar(&sbm.TLSKeyFile, "tls-key", os.Getenv("SUPPORT_BUNDLE_TLS_KEY"), "Path to the TLS key of the manager")
+	managerCmd.PersistentFlags().Int32Var(&sbm.AgentRestartLimit, "agent-restart-limit", int32(utils.EnvGetInt("SUPPORT_BUNDLE_AGENT_RESTART_LIMIT", 3)), "Number of restarts of a crashing agent pod before its node bundle is failed")
+	managerCmd.PersistentFlags().BoolVar(&sbm.SkipNotReadyNodes, "skip-not-ready-nodes", utils.EnvGetBool("SUPPORT_BUNDLE_SKIP_NOT_READY_NODES", false), "Don't wait for node bundles of nodes that are not ready")
+	managerCmd.PersistentFlags().StringVar(&sbm.NewNodes, "new-nodes", os.Getenv("SUPPORT_BUNDLE_NEW_NODES"), "How nodes joining during the collection are handled: ignore (default) or add")
+	managerCmd.PersistentFlags().BoolVar(&sbm.KubeletFallback, "kubelet-fallback", utils.EnvGetBool("SUPPORT_BUNDLE_KUBELET_FALLBACK", true), "Collect partial node bundles through the kubelet proxy from nodes without a working agent")
 	managerCmd.PersistentFlags().StringVar(&sbm.NodeSelector, "node-selector", os.Getenv("SUPPORT_BUNDLE_NODE_SELECTOR"), "NodeSelector of agent DaemonSet. e.g., key1=value1,key2=value2")
+	managerCmd.PersistentFlags().StringVar(&sbm.NodeNames, "node-names", os.Getenv("SUPPORT_BUNDLE_NODE_NAMES"), "List of nodes to collect delimited by , (default is all nodes matching the node selector)")
+	managerCmd.PersistentFlags().StringVar(&sbm.AgentTemplateFile, "agent-template", os.Getenv("SUPPORT_BUNDLE_AGENT_TEMPLATE"), "Path to the agent pod template")
+	managerCmd.PersistentFlags().StringVar(&sbm.AgentTemplateConfigMap, "agent-template-configmap", os.Getenv("SUPPORT_BUNDLE_AGENT_TEMPLATE_CONFIGMAP"), "ConfigMap of the agent pod template in the manager's namespace, with the key agent-template.yaml")
 }
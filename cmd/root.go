@@ -1,7 +1,6 @@
 package cmd
 
 import (
-	"fmt"
 	"os"
 
 	"github.com/rancher/support-bundle-kit/pkg/utils"
@@ -43,7 +42,7 @@ func Execute() {
 
 func init() {
 	cobra.OnInitialize(initConfig)
-	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.support-bundle-utils.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", os.Getenv("SUPPORT_BUNDLE_CONFIG"), "config file (default is $HOME/.support-bundle-utils.yaml)")
 
 	debug := utils.EnvGetBool("SUPPORT_BUNDLE_DEBUG", false)
 	trace := utils.EnvGetBool("SUPPORT_BUNDLE_TRACE", false)
@@ -69,7 +68,5 @@ func initConfig() {
 	viper.AutomaticEnv() // read in environment variables that match
 
 	// If a config file is found, read it in.
-	if err := viper.ReadInConfig(); err == nil {
-		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
-	}
+	cobra.CheckErr(readConfig())
 }
@@ -48,16 +48,20 @@ require (
 	github.com/Jeffail/gabs/v2 v2.6.1
 	github.com/gorilla/mux v1.8.0
 	github.com/harvester/harvester v0.0.2-0.20210528023109-d95127388f17
+	github.com/klauspost/compress v1.10.8
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/pkg/errors v0.9.1
 	github.com/rancher/dapper v0.5.6 // indirect
 	github.com/rancher/wrangler v0.7.3-0.20210219161540-ef7fe9ce2443
 	github.com/sirupsen/logrus v1.8.1
+	github.com/spf13/cast v1.3.1
 	github.com/spf13/cobra v1.1.3
+	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.7.1
 	gopkg.in/yaml.v2 v2.4.0
 	k8s.io/api v0.20.4
 	k8s.io/apimachinery v0.21.0
 	k8s.io/client-go v12.0.0+incompatible
 	k8s.io/metrics v0.20.4
+	sigs.k8s.io/yaml v1.2.0
 )
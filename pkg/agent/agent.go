@@ -0,0 +1,384 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/wrangler/pkg/signals"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/rancher/support-bundle-kit/pkg/archive"
+	"github.com/rancher/support-bundle-kit/pkg/types"
+)
+
+const (
+	uploadTimeout = 10 * time.Minute
+)
+
+// SupportBundleAgent runs on each node, collects a node bundle with the
+// collector spec of the host OS and uploads it to the manager.
+type SupportBundleAgent struct {
+	ManagerURL    string
+	NodeName      string
+	HostPath      string
+	OutputDir     string
+	CollectorsDir string
+	UploadRetries int
+	// ManagerCA is the PEM-encoded CA bundle to verify the manager with
+	ManagerCA string
+	// Token authenticates the agent to the manager
+	Token string
+	// ExtraCollectors are collector specs run in addition to the spec of the
+	// host OS, delimited by ,
+	ExtraCollectors string
+
+	context context.Context
+	errLog  *os.File
+}
+
+func (a *SupportBundleAgent) check() error {
+	if a.ManagerURL == "" {
+		return errors.New("manager URL is not specified")
+	}
+	if a.HostPath == "" {
+		a.HostPath = "/"
+	}
+	if a.NodeName == "" {
+		hostname, err := ioutil.ReadFile(filepath.Join(a.HostPath, "etc", "hostname"))
+		if err != nil {
+			return errors.Wrap(err, "node name is not specified")
+		}
+		a.NodeName = strings.TrimSpace(string(hostname))
+	}
+	if a.OutputDir == "" {
+		a.OutputDir = filepath.Join(os.TempDir(), "support-bundle")
+	}
+	if a.CollectorsDir == "" {
+		a.CollectorsDir = "/etc/support-bundle-kit/collectors"
+	}
+	if a.UploadRetries < 0 {
+		return errors.New("upload retries must not be negative")
+	}
+	return nil
+}
+
+func (a *SupportBundleAgent) getBundleDir() string {
+	return filepath.Join(a.OutputDir, a.NodeName)
+}
+
+func (a *SupportBundleAgent) getBundlefile() string {
+	return filepath.Join(a.OutputDir, "node_bundle.zip")
+}
+
+// Run collects and uploads the node bundle, then idles until the agent is
+// stopped. The agent daemonset is removed by the manager once all node bundles
+// are received. If the node bundle can't be produced, the failure is reported
+// to the manager instead, so it doesn't wait for the node.
+func (a *SupportBundleAgent) Run() error {
+	if err := a.check(); err != nil {
+		return err
+	}
+	a.context = signals.SetupSignalHandler(context.Background())
+
+	if err := os.MkdirAll(a.getBundleDir(), os.FileMode(0755)); err != nil {
+		return err
+	}
+	errLog, err := os.Create(filepath.Join(a.getBundleDir(), "bundleGenerationError.log"))
+	if err != nil {
+		return errors.Wrap(err, "fail to create bundle generation log")
+	}
+	a.errLog = errLog
+	defer errLog.Close()
+
+	if err := a.collectAndUpload(); err != nil {
+		logrus.Errorf("fail to produce node bundle of %s: %v", a.NodeName, err)
+		if rerr := a.reportFailure(err); rerr != nil {
+			return errors.Wrapf(rerr, "fail to report failure %v", err)
+		}
+		logrus.Infof("failure of %s is reported", a.NodeName)
+	} else {
+		logrus.Infof("node bundle of %s is uploaded", a.NodeName)
+	}
+
+	<-a.context.Done()
+	return nil
+}
+
+func (a *SupportBundleAgent) collectAndUpload() error {
+	if err := a.collect(); err != nil {
+		return err
+	}
+	if err := a.compress(); err != nil {
+		return &stepError{step: "compress", err: err}
+	}
+	if err := a.upload(); err != nil {
+		return &stepError{step: "upload", err: err}
+	}
+	return nil
+}
+
+// collect runs the collector spec of the host OS. Failures of optional steps
+// are written to the generation error log, so they're reported to the manager
+// with the rest of the bundle.
+func (a *SupportBundleAgent) collect() error {
+	spec, err := a.loadCollectorSpec()
+	if err != nil {
+		fmt.Fprintf(a.errLog, "Support Bundle: %v\n", err)
+		return &stepError{step: "load collector spec", err: err}
+	}
+
+	c := &collector{
+		context:   a.context,
+		hostPath:  a.HostPath,
+		bundleDir: a.getBundleDir(),
+		errLog:    a.errLog,
+	}
+	return c.run(spec)
+}
+
+// loadCollectorSpec finds the collector spec of the host OS by the ID field of
+// os-release. Derivatives without a spec of their own fall back to the specs
+// of the distributions in ID_LIKE. The extra collectors of the selected
+// profiles are appended to the spec.
+func (a *SupportBundleAgent) loadCollectorSpec() (*CollectorSpec, error) {
+	spec, err := a.loadOSCollectorSpec()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range strings.Split(a.ExtraCollectors, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		file := filepath.Join(a.CollectorsDir, name+".yaml")
+		extra, err := LoadCollectorSpec(file)
+		if err != nil {
+			fmt.Fprintf(a.errLog, "Support Bundle: fail to load extra collector %s: %v\n", name, err)
+			continue
+		}
+		logrus.Infof("collecting node bundle with %s", file)
+		spec.Files = append(spec.Files, extra.Files...)
+		spec.Journald = append(spec.Journald, extra.Journald...)
+		spec.Commands = append(spec.Commands, extra.Commands...)
+	}
+	return spec, nil
+}
+
+func (a *SupportBundleAgent) loadOSCollectorSpec() (*CollectorSpec, error) {
+	osRelease, err := readOSRelease(a.HostPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to determine OS ID")
+	}
+	ids := append([]string{osRelease["ID"]}, strings.Fields(osRelease["ID_LIKE"])...)
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		file := filepath.Join(a.CollectorsDir, id+".yaml")
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			continue
+		}
+		logrus.Infof("collecting node bundle with %s", file)
+		return LoadCollectorSpec(file)
+	}
+	return nil, fmt.Errorf("no collector spec found for OS %s in %s", strings.Join(ids, ","), a.CollectorsDir)
+}
+
+func (a *SupportBundleAgent) compress() error {
+	opts := archive.Options{
+		Prefix: a.NodeName,
+		ErrLog: a.errLog,
+		Last:   []string{filepath.Base(a.errLog.Name())},
+	}
+	if err := archive.Create(a.getBundlefile(), a.getBundleDir(), archive.FormatZip, opts); err != nil {
+		return errors.Wrap(err, "fail to compress node bundle")
+	}
+	return nil
+}
+
+// upload posts the node bundle to the manager
+func (a *SupportBundleAgent) upload() error {
+	url := fmt.Sprintf("%s/nodes/%s", strings.TrimSuffix(a.ManagerURL, "/"), a.NodeName)
+	err := a.post(url, "application/zip", func() (io.ReadCloser, int64, error) {
+		f, err := os.Open(a.getBundlefile())
+		if err != nil {
+			return nil, 0, err
+		}
+		fstat, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+		return f, fstat.Size(), nil
+	})
+	return errors.Wrap(err, "fail to upload node bundle")
+}
+
+// reportFailure posts the failure of the node bundle to the manager
+func (a *SupportBundleAgent) reportFailure(err error) error {
+	failure := &types.NodeFailure{
+		Step:    "collect",
+		Message: err.Error(),
+	}
+	if stepErr, ok := err.(*stepError); ok {
+		failure.Step = stepErr.step
+		failure.Message = stepErr.err.Error()
+		if cmdErr, ok := stepErr.err.(*commandError); ok {
+			failure.Message = cmdErr.err.Error()
+			failure.Stderr = cmdErr.stderr
+			failure.ExitCode = cmdErr.exitCode
+		}
+	}
+	body, err := json.Marshal(failure)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/nodes/%s/failure", strings.TrimSuffix(a.ManagerURL, "/"), a.NodeName)
+	return a.post(url, "application/json", func() (io.ReadCloser, int64, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), int64(len(body)), nil
+	})
+}
+
+// post sends a request body to the manager. Network errors and server errors
+// are retried with exponential backoff, client errors are not. open is called
+// for each attempt to get a fresh body.
+func (a *SupportBundleAgent) post(url, contentType string, open func() (io.ReadCloser, int64, error)) error {
+	client, err := a.newHTTPClient()
+	if err != nil {
+		return err
+	}
+
+	backoff := wait.Backoff{
+		Duration: time.Second,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    a.UploadRetries + 1,
+		Cap:      time.Minute,
+	}
+
+	var lastErr error
+	err = wait.ExponentialBackoff(backoff, func() (bool, error) {
+		if err := a.context.Err(); err != nil {
+			return false, err
+		}
+		retry, err := a.postOnce(client, url, contentType, open)
+		if err == nil {
+			return true, nil
+		}
+		if !retry {
+			return false, err
+		}
+		logrus.Warnf("fail to post %s, retrying: %v", url, err)
+		lastErr = err
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		err = lastErr
+	}
+	return err
+}
+
+// newHTTPClient returns a client that trusts the manager CA bundle, if any, in
+// addition to the system roots
+func (a *SupportBundleAgent) newHTTPClient() (*http.Client, error) {
+	client := &http.Client{Timeout: uploadTimeout}
+	if a.ManagerCA == "" {
+		return client, nil
+	}
+
+	roots, err := x509.SystemCertPool()
+	if err != nil || roots == nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM([]byte(a.ManagerCA)) {
+		return nil, errors.New("no valid certificate found in manager CA bundle")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		RootCAs:    roots,
+		MinVersion: tls.VersionTLS12,
+	}
+	client.Transport = transport
+	return client, nil
+}
+
+// postOnce returns whether a failed request should be retried
+func (a *SupportBundleAgent) postOnce(client *http.Client, url, contentType string, open func() (io.ReadCloser, int64, error)) (bool, error) {
+	body, size, err := open()
+	if err != nil {
+		return false, err
+	}
+	defer body.Close()
+
+	req, err := http.NewRequestWithContext(a.context, http.MethodPost, url, body)
+	if err != nil {
+		return false, err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	if a.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.Token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	err = fmt.Errorf("unexpected response %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	return resp.StatusCode >= 500, err
+}
+
+// readOSRelease reads the fields of /etc/os-release on the host. It's usually
+// a symlink to /usr/lib/os-release, which is resolved inside the host root.
+func readOSRelease(hostPath string) (map[string]string, error) {
+	path, err := resolveInRoot(hostPath, filepath.Join(hostPath, "etc", "os-release"))
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		fields[kv[0]] = strings.Trim(kv[1], `"'`)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if fields["ID"] == "" {
+		return nil, errors.New("ID is not found in os-release")
+	}
+	return fields, nil
+}
@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const builtinCollectorsDir = "../../package/collectors"
+
+func TestBuiltinCollectorSpecs(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join(builtinCollectorsDir, "*.yaml"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no built-in collector specs: %v", err)
+	}
+	for _, file := range files {
+		if _, err := LoadCollectorSpec(file); err != nil {
+			t.Errorf("LoadCollectorSpec(%s) = %v", file, err)
+		}
+	}
+}
+
+func TestLoadOSCollectorSpec(t *testing.T) {
+	tests := []struct {
+		name      string
+		osRelease string
+		// link puts os-release in /usr/lib with an absolute link in /etc
+		link    bool
+		want    string
+		wantErr bool
+	}{
+		{name: "ID", osRelease: "ID=ubuntu\nID_LIKE=debian\n", want: "ubuntu"},
+		{name: "ID_LIKE", osRelease: "ID=\"rocky\"\nID_LIKE=\"rhel centos fedora\"\n", want: "rhel"},
+		{name: "SLES", osRelease: "ID=\"sles\"\nID_LIKE=\"suse\"\n", want: "suse", link: true},
+		{name: "openSUSE", osRelease: "ID=\"opensuse-leap\"\nID_LIKE=\"suse opensuse\"\n", want: "suse", link: true},
+		{name: "unknown", osRelease: "ID=plan9\n", wantErr: true},
+		{name: "no ID", osRelease: "NAME=Linux\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "host")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+
+			file := "etc/os-release"
+			if tt.link {
+				file = "usr/lib/os-release"
+			}
+			if err := os.MkdirAll(filepath.Join(root, filepath.Dir(file)), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(root, file), []byte(tt.osRelease), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.link {
+				if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Symlink("/usr/lib/os-release", filepath.Join(root, "etc/os-release")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			a := &SupportBundleAgent{HostPath: root, CollectorsDir: builtinCollectorsDir}
+			got, err := a.loadOSCollectorSpec()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadOSCollectorSpec() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			want, err := LoadCollectorSpec(filepath.Join(builtinCollectorsDir, tt.want+".yaml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("loadOSCollectorSpec() is not the spec of %s", tt.want)
+			}
+		})
+	}
+}
+
+func TestCollectorSpecsAreNotCopies(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join(builtinCollectorsDir, "*.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// specs without the header comment
+	bodies := make(map[string]string)
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines []string
+		for _, line := range strings.Split(string(b), "\n") {
+			if !strings.HasPrefix(line, "#") {
+				lines = append(lines, line)
+			}
+		}
+		body := strings.Join(lines, "\n")
+		if other, ok := bodies[body]; ok {
+			t.Errorf("%s is a copy of %s, use ID_LIKE instead", file, other)
+		}
+		bodies[body] = file
+	}
+}
@@ -0,0 +1,433 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/rancher/support-bundle-kit/pkg/utils"
+)
+
+const (
+	defaultStepTimeout = time.Minute
+
+	// stderrTailSize is the size of the stderr tail kept for a failed command
+	stderrTailSize = 4096
+
+	// maxSymlinks is the number of symlinks followed to resolve a path, as
+	// MAXSYMLINKS of Linux
+	maxSymlinks = 40
+)
+
+// CollectorSpec describes what to collect on a node. The spec of a node is
+// selected by the ID field of the host's /etc/os-release, e.g., a host with
+// ID=ubuntu is collected with ubuntu.yaml in the collectors directory.
+type CollectorSpec struct {
+	// Files are copied from the host, paths are globs relative to the host root
+	Files []FileSpec `yaml:"files"`
+	// Journald collects logs of systemd units with journalctl on the host
+	Journald []JournaldSpec `yaml:"journald"`
+	// Commands are run and their output is written to the bundle
+	Commands []CommandSpec `yaml:"commands"`
+}
+
+type FileSpec struct {
+	Path string `yaml:"path"`
+	// Dest is the directory in the node bundle, defaults to the top directory
+	Dest string `yaml:"dest"`
+	// MaxSize keeps only the tail of larger files, e.g., 10Mi
+	MaxSize string `yaml:"maxSize"`
+
+	maxSize int64
+}
+
+type JournaldSpec struct {
+	Units []string `yaml:"units"`
+	// Args are passed to journalctl in addition to -u <unit>
+	Args    []string `yaml:"args"`
+	Dest    string   `yaml:"dest"`
+	MaxSize string   `yaml:"maxSize"`
+	Timeout string   `yaml:"timeout"`
+
+	maxSize int64
+	timeout time.Duration
+}
+
+type CommandSpec struct {
+	// Name is the output file name
+	Name    string   `yaml:"name"`
+	Command []string `yaml:"command"`
+	// Chroot runs the command in the host root with the host's binaries
+	Chroot  bool   `yaml:"chroot"`
+	Dest    string `yaml:"dest"`
+	MaxSize string `yaml:"maxSize"`
+	Timeout string `yaml:"timeout"`
+	// Required fails the node bundle if the command fails. The failure is
+	// reported to the manager instead of uploading a bundle.
+	Required bool `yaml:"required"`
+
+	maxSize int64
+	timeout time.Duration
+}
+
+// LoadCollectorSpec reads and validates a collector spec file
+func LoadCollectorSpec(file string) (*CollectorSpec, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	spec := &CollectorSpec{}
+	if err := yaml.UnmarshalStrict(data, spec); err != nil {
+		return nil, fmt.Errorf("fail to parse collector spec %s: %v", file, err)
+	}
+	if err := spec.validate(); err != nil {
+		return nil, fmt.Errorf("invalid collector spec %s: %v", file, err)
+	}
+	return spec, nil
+}
+
+func parseMaxSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	q, err := resource.ParseQuantity(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid max size %q: %v", s, err)
+	}
+	return q.Value(), nil
+}
+
+func parseTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return defaultStepTimeout, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout %q: %v", s, err)
+	}
+	return d, nil
+}
+
+func (s *CollectorSpec) validate() error {
+	var err error
+	for i := range s.Files {
+		f := &s.Files[i]
+		if f.Path == "" {
+			return errors.New("file path is not specified")
+		}
+		if f.maxSize, err = parseMaxSize(f.MaxSize); err != nil {
+			return errors.Wrapf(err, "file %s", f.Path)
+		}
+	}
+	for i := range s.Journald {
+		j := &s.Journald[i]
+		if len(j.Units) == 0 {
+			return errors.New("journald units are not specified")
+		}
+		if j.maxSize, err = parseMaxSize(j.MaxSize); err != nil {
+			return errors.Wrapf(err, "journald %v", j.Units)
+		}
+		if j.timeout, err = parseTimeout(j.Timeout); err != nil {
+			return errors.Wrapf(err, "journald %v", j.Units)
+		}
+	}
+	for i := range s.Commands {
+		c := &s.Commands[i]
+		if c.Name == "" {
+			return errors.New("command name is not specified")
+		}
+		if len(c.Command) == 0 {
+			return fmt.Errorf("command %s: command is not specified", c.Name)
+		}
+		if c.maxSize, err = parseMaxSize(c.MaxSize); err != nil {
+			return errors.Wrapf(err, "command %s", c.Name)
+		}
+		if c.timeout, err = parseTimeout(c.Timeout); err != nil {
+			return errors.Wrapf(err, "command %s", c.Name)
+		}
+	}
+	return nil
+}
+
+// collector runs a collector spec against the host root
+type collector struct {
+	context   context.Context
+	hostPath  string
+	bundleDir string
+	errLog    io.Writer
+}
+
+// commandError is the failure of a command, with its exit code if it exited
+// and the tail of its stderr
+type commandError struct {
+	err      error
+	exitCode *int
+	stderr   string
+}
+
+func (e *commandError) Error() string {
+	if e.stderr == "" {
+		return e.err.Error()
+	}
+	return fmt.Sprintf("%v: %s", e.err, e.stderr)
+}
+
+// stepError is the failure of a required step of the collector spec
+type stepError struct {
+	step string
+	err  error
+}
+
+func (e *stepError) Error() string {
+	return fmt.Sprintf("%s failed: %v", e.step, e.err)
+}
+
+// run runs the collector spec. Failures are written to the generation error
+// log, only the failure of a required command is returned.
+func (c *collector) run(spec *CollectorSpec) error {
+	for _, f := range spec.Files {
+		c.collectFiles(f)
+	}
+	for _, j := range spec.Journald {
+		for _, unit := range j.Units {
+			cmd := CommandSpec{
+				Name:    unit + ".log",
+				Command: append([]string{"/usr/bin/journalctl", "-u", unit}, j.Args...),
+				Chroot:  true,
+				Dest:    j.Dest,
+				maxSize: j.maxSize,
+				timeout: j.timeout,
+			}
+			_ = c.runCommand(cmd)
+		}
+	}
+	for _, cmd := range spec.Commands {
+		if err := c.runCommand(cmd); err != nil && cmd.Required {
+			return &stepError{step: "command " + cmd.Name, err: err}
+		}
+	}
+	return nil
+}
+
+func (c *collector) collectFiles(f FileSpec) {
+	matches, err := filepath.Glob(filepath.Join(c.hostPath, f.Path))
+	if err != nil {
+		fmt.Fprintf(c.errLog, "Support Bundle: invalid file pattern %s: %v\n", f.Path, err)
+		return
+	}
+	if len(matches) == 0 {
+		logrus.Debugf("no files match %s", f.Path)
+		return
+	}
+
+	destDir := filepath.Join(c.bundleDir, f.Dest)
+	for _, match := range matches {
+		// symlinks of matches, e.g., /etc/os-release, are followed inside
+		// the host root
+		src, err := resolveInRoot(c.hostPath, match)
+		if err != nil {
+			fmt.Fprintf(c.errLog, "Support Bundle: failed to collect %s: %v\n", match, err)
+			continue
+		}
+		c.copyTree(src, filepath.Join(destDir, filepath.Base(match)), f.maxSize)
+	}
+}
+
+// copyTree copies a file, or the regular files of a directory recursively.
+// Other entries, e.g., symlinks inside the directory, are skipped and written
+// to the generation error log.
+func (c *collector) copyTree(src, dest string, maxSize int64) {
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			fmt.Fprintf(c.errLog, "Support Bundle: failed to collect %s: %v\n", path, err)
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			fmt.Fprintf(c.errLog, "Support Bundle: skipped %s: not a regular file (%v)\n", path, info.Mode()&os.ModeType)
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if err := copyTail(path, filepath.Join(dest, rel), maxSize); err != nil {
+			fmt.Fprintf(c.errLog, "Support Bundle: failed to collect %s: %v\n", path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(c.errLog, "Support Bundle: failed to collect %s: %v\n", src, err)
+	}
+}
+
+// resolveInRoot resolves the symlinks of path as if root was the root
+// directory, like chroot does. Absolute link targets are relative to root and
+// .. never leaves it, so links of the host can't point into the agent's
+// container.
+func resolveInRoot(root, path string) (string, error) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of %s", path, root)
+	}
+
+	pending := strings.Split(rel, string(filepath.Separator))
+	resolved := ""
+	links := 0
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			if resolved = filepath.Dir(resolved); resolved == "." {
+				resolved = ""
+			}
+			continue
+		}
+
+		next := filepath.Join(resolved, name)
+		info, err := os.Lstat(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		if links++; links > maxSymlinks {
+			return "", fmt.Errorf("too many levels of symbolic links in %s", path)
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = ""
+		}
+		pending = append(strings.Split(target, string(filepath.Separator)), pending...)
+	}
+	return filepath.Join(root, resolved), nil
+}
+
+// runCommand runs a command and writes its output to the bundle. Failures are
+// written to the generation error log with the tail of stderr.
+func (c *collector) runCommand(spec CommandSpec) error {
+	dest := filepath.Join(c.bundleDir, spec.Dest, spec.Name)
+	err := c.execute(spec, dest)
+	if err != nil {
+		fmt.Fprintf(c.errLog, "Support Bundle: command %s failed: %v\n", spec.Name, err)
+	}
+	return err
+}
+
+func (c *collector) execute(spec CommandSpec, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), os.FileMode(0755)); err != nil {
+		return err
+	}
+	out, err := utils.NewTailFile(dest, spec.maxSize)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	ctx, cancel := context.WithTimeout(c.context, spec.timeout)
+	defer cancel()
+
+	args := spec.Command
+	if spec.Chroot {
+		args = append([]string{"chroot", c.hostPath}, args...)
+	}
+	logrus.Debugf("running %v", args)
+
+	stderr := &tailBuffer{size: stderrTailSize}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = out
+	cmd.Stderr = stderr
+	err = cmd.Run()
+	if err != nil {
+		cmdErr := &commandError{err: err, stderr: strings.TrimSpace(stderr.String())}
+		if ctx.Err() == context.DeadlineExceeded {
+			cmdErr.err = fmt.Errorf("timed out after %v", spec.timeout)
+		} else if exitErr, ok := err.(*exec.ExitError); ok {
+			exitCode := exitErr.ExitCode()
+			cmdErr.exitCode = &exitCode
+		}
+		err = cmdErr
+	}
+
+	if cerr := out.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if out.Truncated() {
+		fmt.Fprintf(c.errLog, "Support Bundle: output of command %s is truncated to the last %d bytes\n", spec.Name, spec.maxSize)
+	}
+	return err
+}
+
+// copyTail copies the last maxSize bytes of src to dst, or the whole file if
+// maxSize is zero.
+func copyTail(src, dst string, maxSize int64) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if maxSize > 0 && info.Size() > maxSize {
+		if _, err := in.Seek(info.Size()-maxSize, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), os.FileMode(0755)); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	r := io.Reader(in)
+	if maxSize > 0 {
+		r = io.LimitReader(in, maxSize)
+	}
+	_, err = io.Copy(out, bufio.NewReader(r))
+	return err
+}
+
+// tailBuffer keeps the last size bytes written to it
+type tailBuffer struct {
+	bytes.Buffer
+	size int
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	n, _ := t.Buffer.Write(p)
+	if t.Len() > t.size {
+		t.Next(t.Len() - t.size)
+	}
+	return n, nil
+}
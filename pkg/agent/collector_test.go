@@ -0,0 +1,155 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newHostRoot returns a host root with /usr/lib/os-release, linked by an
+// absolute link from /etc/os-release as on most distributions, and links
+// escaping the root or looping
+func newHostRoot(t *testing.T) string {
+	root, err := ioutil.TempDir("", "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	for _, dir := range []string{"etc", "usr/lib", "var/log/pods"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		"usr/lib/os-release": "ID=sles\n",
+		"etc/hostname":       "node-1\n",
+	}
+	for file, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(root, file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"etc/os-release":        "/usr/lib/os-release",
+		"etc/os-release-rel":    "../usr/lib/os-release",
+		"etc/escape":            "../../../../etc/hostname",
+		"etc/loop":              "loop",
+		"var/log/pods/link.log": "/usr/lib/os-release",
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestResolveInRoot(t *testing.T) {
+	root := newHostRoot(t)
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{path: "usr/lib/os-release", want: "usr/lib/os-release"},
+		{path: "etc/os-release", want: "usr/lib/os-release"},
+		{path: "etc/os-release-rel", want: "usr/lib/os-release"},
+		// .. stops at the root like chroot does
+		{path: "etc/escape", want: "etc/hostname"},
+		{path: "etc/loop", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := resolveInRoot(root, filepath.Join(root, tt.path))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("resolveInRoot(%s) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if want := filepath.Join(root, tt.want); got != want {
+			t.Errorf("resolveInRoot(%s) = %s, want %s", tt.path, got, want)
+		}
+	}
+
+	if _, err := resolveInRoot(root, filepath.Dir(root)); err == nil {
+		t.Errorf("resolveInRoot() of a path outside of the root succeeded")
+	}
+}
+
+func TestCollectFilesFollowsSymlinks(t *testing.T) {
+	root := newHostRoot(t)
+	bundleDir, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(bundleDir)
+
+	errLog := &bytes.Buffer{}
+	c := &collector{
+		context:   context.Background(),
+		hostPath:  root,
+		bundleDir: bundleDir,
+		errLog:    errLog,
+	}
+	c.collectFiles(FileSpec{Path: "/etc/os-release", Dest: "os"})
+	c.collectFiles(FileSpec{Path: "/var/log/pods", Dest: "logs"})
+
+	b, err := ioutil.ReadFile(filepath.Join(bundleDir, "os", "os-release"))
+	if err != nil {
+		t.Fatalf("symlinked match is not collected: %v", err)
+	}
+	if string(b) != "ID=sles\n" {
+		t.Errorf("os-release = %q, want the target's content", b)
+	}
+
+	// symlinks inside a matched directory are skipped and logged
+	if _, err := os.Stat(filepath.Join(bundleDir, "logs", "pods", "link.log")); !os.IsNotExist(err) {
+		t.Errorf("symlink inside a directory is collected: %v", err)
+	}
+	if !strings.Contains(errLog.String(), "skipped "+filepath.Join(root, "var/log/pods/link.log")) {
+		t.Errorf("skipped symlink is not logged: %q", errLog.String())
+	}
+}
+
+func TestRunCommandKeepsTail(t *testing.T) {
+	bundleDir, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(bundleDir)
+
+	errLog := &bytes.Buffer{}
+	c := &collector{
+		context:   context.Background(),
+		hostPath:  "/",
+		bundleDir: bundleDir,
+		errLog:    errLog,
+	}
+	spec := CommandSpec{
+		Name:    "seq.log",
+		Command: []string{"/bin/sh", "-c", "seq 1 100000"},
+		maxSize: 12,
+		timeout: time.Minute,
+	}
+	if err := c.runCommand(spec); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(bundleDir, "seq.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "99999\n100000\n"[1:]; string(b) != want {
+		t.Errorf("output = %q, want %q", b, want)
+	}
+	if !strings.Contains(errLog.String(), "output of command seq.log is truncated") {
+		t.Errorf("truncation is not logged: %q", errLog.String())
+	}
+}
@@ -0,0 +1,284 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+type Format string
+
+const (
+	FormatZip     = Format("zip")
+	FormatTarGzip = Format("tar.gz")
+	FormatTarZstd = Format("tar.zst")
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatZip, FormatTarGzip, FormatTarZstd:
+		return f, nil
+	case "":
+		return FormatZip, nil
+	}
+	return "", fmt.Errorf("unsupported archive format %q", s)
+}
+
+// Extension returns the file name extension of the format, including the
+// leading dot.
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case FormatTarGzip:
+		return "application/gzip"
+	case FormatTarZstd:
+		return "application/zstd"
+	}
+	return "application/zip"
+}
+
+type Options struct {
+	// Prefix is the directory name all entries are put under.
+	Prefix string
+	// ModTime is set on every entry if not zero, so archives of the same
+	// content are identical.
+	ModTime time.Time
+	// ErrLog receives errors of files that can't be archived. These files are
+	// skipped and the rest of the archive is still written.
+	ErrLog io.Writer
+	// Last lists paths relative to the source directory that are archived
+	// after all other files, e.g., a log that's still written to while
+	// archiving.
+	Last []string
+}
+
+type entryWriter interface {
+	writeDir(name string, info os.FileInfo) error
+	// writeFile returns a fatal error if the archive stream can't be
+	// written anymore.
+	writeFile(name string, info os.FileInfo, r io.Reader) error
+	Close() error
+}
+
+// Create archives the content of srcDir into the file dst.
+func Create(dst string, srcDir string, format Format, opts Options) (err error) {
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	return Write(f, srcDir, format, opts)
+}
+
+// Write archives the content of srcDir into w. Entries are written in lexical
+// order.
+func Write(w io.Writer, srcDir string, format Format, opts Options) error {
+	if opts.ErrLog == nil {
+		opts.ErrLog = ioutil.Discard
+	}
+
+	ew, err := newEntryWriter(w, format)
+	if err != nil {
+		return err
+	}
+
+	last := make(map[string]struct{}, len(opts.Last))
+	for _, p := range opts.Last {
+		last[filepath.Clean(p)] = struct{}{}
+	}
+
+	var paths []string
+	err = filepath.Walk(srcDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			fmt.Fprintf(opts.ErrLog, "Support Bundle: failed to archive %v: %v\n", p, err)
+			return nil
+		}
+		rel, err := filepath.Rel(srcDir, p)
+		if err != nil {
+			return err
+		}
+		if _, ok := last[rel]; ok {
+			return nil
+		}
+		paths = append(paths, rel)
+		return nil
+	})
+	if err != nil {
+		ew.Close()
+		return err
+	}
+	sort.Strings(paths)
+	paths = append(paths, opts.Last...)
+
+	for _, rel := range paths {
+		if err := writeEntry(ew, srcDir, rel, opts); err != nil {
+			ew.Close()
+			return errors.Wrapf(err, "fail to archive %s", rel)
+		}
+	}
+	return ew.Close()
+}
+
+func writeEntry(ew entryWriter, srcDir, rel string, opts Options) error {
+	p := filepath.Join(srcDir, rel)
+	name := path.Join(opts.Prefix, filepath.ToSlash(rel))
+
+	info, err := os.Lstat(p)
+	if err != nil {
+		fmt.Fprintf(opts.ErrLog, "Support Bundle: failed to archive %v: %v\n", p, err)
+		return nil
+	}
+	info = withModTime(info, opts.ModTime)
+
+	switch {
+	case info.IsDir():
+		if rel == "." {
+			if opts.Prefix == "" {
+				return nil
+			}
+			name = opts.Prefix
+		}
+		return ew.writeDir(name+"/", info)
+	case info.Mode().IsRegular():
+		f, err := os.Open(p)
+		if err != nil {
+			fmt.Fprintf(opts.ErrLog, "Support Bundle: failed to archive %v: %v\n", p, err)
+			return nil
+		}
+		defer f.Close()
+		return ew.writeFile(name, info, f)
+	default:
+		fmt.Fprintf(opts.ErrLog, "Support Bundle: skip archiving %v: unsupported file type %v\n", p, info.Mode()&os.ModeType)
+		return nil
+	}
+}
+
+func newEntryWriter(w io.Writer, format Format) (entryWriter, error) {
+	switch format {
+	case FormatZip:
+		return &zipWriter{w: zip.NewWriter(w)}, nil
+	case FormatTarGzip:
+		return newTarWriter(gzip.NewWriter(w)), nil
+	case FormatTarZstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		return newTarWriter(zw), nil
+	}
+	return nil, fmt.Errorf("unsupported archive format %q", format)
+}
+
+type zipWriter struct {
+	w *zip.Writer
+}
+
+func (z *zipWriter) writeDir(name string, info os.FileInfo) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	_, err = z.w.CreateHeader(header)
+	return err
+}
+
+func (z *zipWriter) writeFile(name string, info os.FileInfo, r io.Reader) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+	w, err := z.w.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (z *zipWriter) Close() error {
+	return z.w.Close()
+}
+
+type tarWriter struct {
+	w          *tar.Writer
+	compressor io.WriteCloser
+}
+
+func newTarWriter(compressor io.WriteCloser) *tarWriter {
+	return &tarWriter{
+		w:          tar.NewWriter(compressor),
+		compressor: compressor,
+	}
+}
+
+func (t *tarWriter) writeHeader(name string, info os.FileInfo) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	// drop owner information to keep archives reproducible
+	header.Uid, header.Gid = 0, 0
+	header.Uname, header.Gname = "", ""
+	header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+	return t.w.WriteHeader(header)
+}
+
+func (t *tarWriter) writeDir(name string, info os.FileInfo) error {
+	return t.writeHeader(name, info)
+}
+
+func (t *tarWriter) writeFile(name string, info os.FileInfo, r io.Reader) error {
+	if err := t.writeHeader(name, info); err != nil {
+		return err
+	}
+	// the size is already in the header, a file that grows afterwards is
+	// truncated to it
+	_, err := io.CopyN(t.w, r, info.Size())
+	return err
+}
+
+func (t *tarWriter) Close() error {
+	if err := t.w.Close(); err != nil {
+		t.compressor.Close()
+		return err
+	}
+	return t.compressor.Close()
+}
+
+type fileInfo struct {
+	os.FileInfo
+	modTime time.Time
+}
+
+func (f *fileInfo) ModTime() time.Time {
+	return f.modTime
+}
+
+func withModTime(info os.FileInfo, modTime time.Time) os.FileInfo {
+	if modTime.IsZero() {
+		return info
+	}
+	return &fileInfo{FileInfo: info, modTime: modTime}
+}
@@ -0,0 +1,223 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var testModTime = time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// newSourceDir returns a bundle directory with nested files and the error log
+func newSourceDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	files := map[string]string{
+		"bundleGenerationError.log":   "Support Bundle: failed\n",
+		"yamls/cluster/v1/nodes.yaml": "items: []\n",
+		"nodes/node-1.zip":            "node bundle",
+		"logs/a/b.log":                "log line\n",
+		"metadata.yaml":               "version: v1\n",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func testOptions() Options {
+	return Options{
+		Prefix:  "supportbundle_test",
+		ModTime: testModTime,
+		Last:    []string{"bundleGenerationError.log"},
+	}
+}
+
+// entries lists the names of the entries of an archive in order
+func entries(t *testing.T, b []byte, format Format) []string {
+	var names []string
+	switch format {
+	case FormatZip:
+		r, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range r.File {
+			names = append(names, f.Name)
+		}
+		return names
+	case FormatTarGzip:
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tarEntries(t, r, nil)
+	case FormatTarZstd:
+		r, err := zstd.NewReader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		return tarEntries(t, r, nil)
+	}
+	t.Fatalf("unknown format %s", format)
+	return nil
+}
+
+// tarEntries lists the names of the entries of a tar stream, check is called
+// on each header
+func tarEntries(t *testing.T, r io.Reader, check func(*tar.Header)) []string {
+	var names []string
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if check != nil {
+			check(header)
+		}
+		names = append(names, header.Name)
+	}
+}
+
+func TestWriteIsDeterministic(t *testing.T) {
+	for _, format := range []Format{FormatZip, FormatTarGzip, FormatTarZstd} {
+		t.Run(string(format), func(t *testing.T) {
+			dir := newSourceDir(t)
+			var first bytes.Buffer
+			if err := Write(&first, dir, format, testOptions()); err != nil {
+				t.Fatal(err)
+			}
+
+			// the times of the files don't change the archive
+			later := time.Now().Add(time.Hour)
+			if err := os.Chtimes(filepath.Join(dir, "metadata.yaml"), later, later); err != nil {
+				t.Fatal(err)
+			}
+			var second bytes.Buffer
+			if err := Write(&second, dir, format, testOptions()); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(first.Bytes(), second.Bytes()) {
+				t.Errorf("archives of the same content differ")
+			}
+		})
+	}
+}
+
+func TestWriteOrder(t *testing.T) {
+	want := []string{
+		"supportbundle_test/",
+		"supportbundle_test/logs/",
+		"supportbundle_test/logs/a/",
+		"supportbundle_test/logs/a/b.log",
+		"supportbundle_test/metadata.yaml",
+		"supportbundle_test/nodes/",
+		"supportbundle_test/nodes/node-1.zip",
+		"supportbundle_test/yamls/",
+		"supportbundle_test/yamls/cluster/",
+		"supportbundle_test/yamls/cluster/v1/",
+		"supportbundle_test/yamls/cluster/v1/nodes.yaml",
+		// the error log is written last
+		"supportbundle_test/bundleGenerationError.log",
+	}
+	for _, format := range []Format{FormatZip, FormatTarGzip, FormatTarZstd} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, newSourceDir(t), format, testOptions()); err != nil {
+				t.Fatal(err)
+			}
+			if got := entries(t, buf.Bytes(), format); !reflect.DeepEqual(got, want) {
+				t.Errorf("entries = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteTarHeaders(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, newSourceDir(t), FormatTarGzip, testOptions()); err != nil {
+		t.Fatal(err)
+	}
+	r, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarEntries(t, r, func(header *tar.Header) {
+		if header.Uid != 0 || header.Gid != 0 || header.Uname != "" || header.Gname != "" {
+			t.Errorf("%s: owner is kept: uid %d gid %d uname %q gname %q", header.Name, header.Uid, header.Gid, header.Uname, header.Gname)
+		}
+		if !header.ModTime.Equal(testModTime) {
+			t.Errorf("%s: mod time = %v, want %v", header.Name, header.ModTime, testModTime)
+		}
+	})
+}
+
+func TestWriteSkipsUnsupportedFiles(t *testing.T) {
+	dir := newSourceDir(t)
+	if err := os.Symlink("metadata.yaml", filepath.Join(dir, "link.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	var buf, errLog bytes.Buffer
+	opts := testOptions()
+	opts.ErrLog = &errLog
+	if err := Write(&buf, dir, FormatZip, opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range entries(t, buf.Bytes(), FormatZip) {
+		if name == "supportbundle_test/link.yaml" {
+			t.Errorf("symlink is archived")
+		}
+	}
+	if !bytes.Contains(errLog.Bytes(), []byte("link.yaml")) {
+		t.Errorf("skipped symlink is not logged: %q", errLog.String())
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Format
+		wantErr bool
+	}{
+		{in: "", want: FormatZip},
+		{in: "zip", want: FormatZip},
+		{in: "tar.gz", want: FormatTarGzip},
+		{in: "tar.zst", want: FormatTarZstd},
+		{in: "rar", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseFormat(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseFormat(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseFormat(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
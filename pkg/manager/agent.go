@@ -2,6 +2,9 @@ package manager
 
 import (
 	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/rancher/support-bundle-kit/pkg/types"
@@ -10,6 +13,8 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 )
 
 type AgentDaemonSet struct {
@@ -20,37 +25,77 @@ func (a *AgentDaemonSet) getDaemonSetName() string {
 	return fmt.Sprintf("supportbundle-agent-%s", a.sbm.BundleName)
 }
 
-func (a *AgentDaemonSet) Create(image string, managerURL string) error {
-	dsName := a.getDaemonSetName()
-	logrus.Debugf("creating daemonset %s with image %s", dsName, image)
+func (a *AgentDaemonSet) getSecretName() string {
+	return a.getDaemonSetName()
+}
 
-	// get manager pod for owner reference
+// getOwnerReferences returns the owner references of agent resources. They are
+// owned by the manager pod, so they are garbage-collected with it.
+func (a *AgentDaemonSet) getOwnerReferences() ([]metav1.OwnerReference, error) {
 	labels := fmt.Sprintf("app=%s,%s=%s", types.SupportBundleManager, types.SupportBundleLabelKey, a.sbm.BundleName)
 
 	pods, err := a.sbm.k8s.GetPodsListByLabels(a.sbm.PodNamespace, labels)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if len(pods.Items) != 1 {
-		return errors.New("more than one support bundle manager pods are found")
+		return nil, errors.New("more than one support bundle manager pods are found")
 	}
 	managerPod := pods.Items[0]
 
-	daemonSet := &appsv1.DaemonSet{
+	return []metav1.OwnerReference{
+		{
+			// not sure why managerPod has empty Kind and APIVersion
+			Name:       managerPod.Name,
+			Kind:       "Pod",
+			UID:        managerPod.UID,
+			APIVersion: "v1",
+		},
+	}, nil
+}
+
+// createSecret stores the token agents authenticate their uploads with
+func (a *AgentDaemonSet) createSecret(ownerReferences []metav1.OwnerReference, token string) error {
+	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      dsName,
-			Namespace: a.sbm.PodNamespace,
-			OwnerReferences: []metav1.OwnerReference{
-				{
-					// not sure why managerPod has empty Kind and APIVersion
-					Name:       managerPod.Name,
-					Kind:       "Pod",
-					UID:        managerPod.UID,
-					APIVersion: "v1",
-				},
+			Name:            a.getSecretName(),
+			Namespace:       a.sbm.PodNamespace,
+			OwnerReferences: ownerReferences,
+			Labels: map[string]string{
+				"app":                       types.SupportBundleAgent,
+				types.SupportBundleLabelKey: a.sbm.BundleName,
 			},
 		},
+		StringData: map[string]string{
+			types.AgentTokenKey: token,
+		},
+	}
+	_, err := a.sbm.k8s.CreateSecret(a.sbm.PodNamespace, secret)
+	return err
+}
+
+func (a *AgentDaemonSet) Create(image string, managerURL string, token string) error {
+	dsName := a.getDaemonSetName()
+	logrus.Debugf("creating daemonset %s with image %s", dsName, image)
+
+	ownerReferences, err := a.getOwnerReferences()
+	if err != nil {
+		return err
+	}
+
+	if err := a.createSecret(ownerReferences, token); err != nil {
+		return errors.Wrap(err, "fail to create agent secret")
+	}
+
+	template := a.sbm.agentTemplate
+
+	daemonSet := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            dsName,
+			Namespace:       a.sbm.PodNamespace,
+			OwnerReferences: ownerReferences,
+		},
 		Spec: appsv1.DaemonSetSpec{
 			Selector: &metav1.LabelSelector{
 				MatchLabels: map[string]string{
@@ -66,19 +111,19 @@ func (a *AgentDaemonSet) Create(image string, managerURL string) error {
 					},
 				},
 				Spec: corev1.PodSpec{
-					NodeSelector: a.sbm.getNodeSelector(),
-					Tolerations: []corev1.Toleration{
-						{
-							Key:   types.DrainKey,
-							Value: "scheduling",
-						},
-					},
+					NodeSelector:       a.sbm.getNodeSelector(),
+					Affinity:           a.sbm.getAgentAffinity(),
+					Tolerations:        template.getTolerations(),
+					PriorityClassName:  template.PriorityClassName,
+					ImagePullSecrets:   template.ImagePullSecrets,
+					ServiceAccountName: template.ServiceAccountName,
 					Containers: []corev1.Container{
 						{
 							Name:            "agent",
 							Image:           image,
-							Args:            []string{"/usr/bin/support-bundle-collector.sh"},
+							Args:            []string{"/usr/bin/support-bundle-kit", "agent"},
 							ImagePullPolicy: corev1.PullPolicy(a.sbm.ImagePullPolicy),
+							Resources:       template.Resources,
 							SecurityContext: &corev1.SecurityContext{
 								Capabilities: &corev1.Capabilities{
 									Add: []corev1.Capability{"SYSLOG"},
@@ -101,6 +146,25 @@ func (a *AgentDaemonSet) Create(image string, managerURL string) error {
 									Name:  "SUPPORT_BUNDLE_MANAGER_URL",
 									Value: managerURL,
 								},
+								{
+									Name:  "SUPPORT_BUNDLE_EXTRA_COLLECTORS",
+									Value: strings.Join(a.sbm.profile.NodeCollectors, ","),
+								},
+								{
+									Name:  "SUPPORT_BUNDLE_MANAGER_CA",
+									Value: string(a.sbm.caBundle),
+								},
+								{
+									Name: "SUPPORT_BUNDLE_AGENT_TOKEN",
+									ValueFrom: &corev1.EnvVarSource{
+										SecretKeyRef: &corev1.SecretKeySelector{
+											LocalObjectReference: corev1.LocalObjectReference{
+												Name: a.getSecretName(),
+											},
+											Key: types.AgentTokenKey,
+										},
+									},
+								},
 							},
 							VolumeMounts: []corev1.VolumeMount{
 								{
@@ -129,11 +193,167 @@ func (a *AgentDaemonSet) Create(image string, managerURL string) error {
 	return err
 }
 
+// agentPodState is the state of the agent pod on a node
+type agentPodState struct {
+	Pod   string
+	State string
+}
+
+func (s agentPodState) String() string {
+	return fmt.Sprintf("agent pod %s: %s", s.Pod, s.State)
+}
+
+func (a *AgentDaemonSet) getPodLabels() string {
+	return fmt.Sprintf("app=%s,%s=%s", types.SupportBundleAgent, types.SupportBundleLabelKey, a.sbm.BundleName)
+}
+
+// Watch calls handler on each change of agent pods until stopCh is closed
+func (a *AgentDaemonSet) Watch(stopCh <-chan struct{}, handler func(pod *corev1.Pod)) {
+	informer := a.sbm.k8s.NewPodInformer(a.sbm.PodNamespace, a.getPodLabels())
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if pod, ok := obj.(*corev1.Pod); ok {
+				handler(pod)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if pod, ok := obj.(*corev1.Pod); ok {
+				handler(pod)
+			}
+		},
+	})
+	go informer.Run(stopCh)
+}
+
+// GetPodStates returns the states of agent pods by the nodes they run on
+func (a *AgentDaemonSet) GetPodStates() (map[string]agentPodState, error) {
+	pods, err := a.sbm.k8s.GetPodsListByLabels(a.sbm.PodNamespace, a.getPodLabels())
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]agentPodState)
+	for _, pod := range pods.Items {
+		node := getPodTargetNode(&pod)
+		if node == "" {
+			continue
+		}
+		states[node] = agentPodState{Pod: pod.Name, State: describePodState(&pod)}
+	}
+	return states, nil
+}
+
+// getPodTargetNode returns the node a pod runs on. DaemonSet pods that are not
+// scheduled yet only carry their target node in the node affinity.
+func getPodTargetNode(pod *corev1.Pod) string {
+	if pod.Spec.NodeName != "" {
+		return pod.Spec.NodeName
+	}
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil {
+		return ""
+	}
+	required := pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if required == nil {
+		return ""
+	}
+	for _, term := range required.NodeSelectorTerms {
+		for _, field := range term.MatchFields {
+			if field.Key == "metadata.name" && field.Operator == corev1.NodeSelectorOpIn && len(field.Values) == 1 {
+				return field.Values[0]
+			}
+		}
+	}
+	return ""
+}
+
+func describePodState(pod *corev1.Pod) string {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionFalse {
+			return fmt.Sprintf("%s (%s: %s)", pod.Status.Phase, cond.Reason, cond.Message)
+		}
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting != nil && status.State.Waiting.Reason != "" {
+			return fmt.Sprintf("%s (%s: %s)", pod.Status.Phase, status.State.Waiting.Reason, status.State.Waiting.Message)
+		}
+		if status.State.Terminated != nil {
+			return fmt.Sprintf("%s (container terminated: %s, exit code %d)", pod.Status.Phase,
+				status.State.Terminated.Reason, status.State.Terminated.ExitCode)
+		}
+	}
+	return string(pod.Status.Phase)
+}
+
+// getPodFailure returns why an agent pod will never upload a node bundle, or
+// an empty string if it still may. Crashing pods are given restartLimit
+// restarts before they're considered failed.
+func getPodFailure(pod *corev1.Pod, restartLimit int32) string {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionFalse && cond.Reason == corev1.PodReasonUnschedulable {
+			return fmt.Sprintf("%s: %s", cond.Reason, cond.Message)
+		}
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		waiting := status.State.Waiting
+		if waiting == nil {
+			continue
+		}
+		switch waiting.Reason {
+		case "ImagePullBackOff", "InvalidImageName":
+			return fmt.Sprintf("%s: %s", waiting.Reason, waiting.Message)
+		case "CrashLoopBackOff":
+			if status.RestartCount >= restartLimit {
+				return fmt.Sprintf("%s after %d restarts: %s", waiting.Reason, status.RestartCount, waiting.Message)
+			}
+		}
+	}
+	return ""
+}
+
+// CollectLogs writes the logs of agent pods into dir, by the nodes they run on.
+// Logs of the previous container are collected too if it restarted.
+func (a *AgentDaemonSet) CollectLogs(dir string, errLog io.Writer) {
+	pods, err := a.sbm.k8s.GetPodsListByLabels(a.sbm.PodNamespace, a.getPodLabels())
+	if err != nil {
+		fmt.Fprintf(errLog, "Support Bundle: failed to get agent pods: %v\n", err)
+		return
+	}
+
+	for _, pod := range pods.Items {
+		node := getPodTargetNode(&pod)
+		if node == "" {
+			node = pod.Name
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			requests := map[string]*rest.Request{
+				status.Name + ".log": a.sbm.k8s.GetPodContainerLogRequest(a.sbm.PodNamespace, pod.Name, status.Name),
+			}
+			if status.RestartCount > 0 {
+				requests[status.Name+".previous.log"] = a.sbm.k8s.GetPodContainerPreviousLogRequest(a.sbm.PodNamespace, pod.Name, status.Name)
+			}
+			for name, req := range requests {
+				stream, err := req.Stream(a.sbm.context)
+				if err != nil {
+					fmt.Fprintf(errLog, "Support Bundle: cannot get log for agent pod %v container %v: %v\n",
+						pod.Name, status.Name, err)
+					continue
+				}
+				streamLogToFile(stream, filepath.Join(dir, node, name), a.sbm.redactor, errLog)
+				stream.Close()
+			}
+		}
+	}
+}
+
 func (a *AgentDaemonSet) Cleanup() error {
 	dsName := a.getDaemonSetName()
 	err := a.sbm.k8s.DeleteDaemonSets(a.sbm.PodNamespace, dsName)
 	if err != nil && !apierrors.IsNotFound(err) {
 		return err
 	}
+	err = a.sbm.k8s.DeleteSecret(a.sbm.PodNamespace, a.getSecretName())
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
 	return nil
 }
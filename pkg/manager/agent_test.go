@@ -0,0 +1,98 @@
+package manager
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/rancher/support-bundle-kit/pkg/types"
+	"github.com/rancher/support-bundle-kit/pkg/utils"
+)
+
+func newManagerPod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "supportbundle-manager-sb-test",
+			Namespace: "cattle-system",
+			UID:       "manager-uid",
+			Labels: map[string]string{
+				"app":                       types.SupportBundleManager,
+				types.SupportBundleLabelKey: "sb-test",
+			},
+		},
+	}
+}
+
+func TestCreateAgentsWithToken(t *testing.T) {
+	clientSet := fake.NewSimpleClientset(newManagerPod())
+	m := newTestManagerForClientSet(t, clientSet)
+	m.agentTemplate = &AgentTemplate{}
+	m.profile = &Profile{}
+	token, err := utils.RandomToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	agents := &AgentDaemonSet{sbm: m}
+	if err := agents.Create("support-bundle-kit:test", "http://10.0.0.1:8080", token); err != nil {
+		t.Fatal(err)
+	}
+
+	// the token is only in the secret, agents read it from there
+	secret, err := clientSet.CoreV1().Secrets("cattle-system").Get(context.Background(), agents.getSecretName(), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := secret.StringData[types.AgentTokenKey]; got != token {
+		t.Errorf("token in the secret = %q, want %q", got, token)
+	}
+	if refs := secret.OwnerReferences; len(refs) != 1 || refs[0].UID != "manager-uid" {
+		t.Errorf("secret is not owned by the manager pod: %v", refs)
+	}
+
+	daemonSet, err := clientSet.AppsV1().DaemonSets("cattle-system").Get(context.Background(), agents.getDaemonSetName(), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, env := range daemonSet.Spec.Template.Spec.Containers[0].Env {
+		if env.Value == token {
+			t.Errorf("token is in the plain env %s of the agent", env.Name)
+		}
+		if env.Name != "SUPPORT_BUNDLE_AGENT_TOKEN" {
+			continue
+		}
+		found = true
+		ref := env.ValueFrom.SecretKeyRef
+		if ref == nil || ref.Name != agents.getSecretName() || ref.Key != types.AgentTokenKey {
+			t.Errorf("agent token env = %+v, want the key of the secret", env.ValueFrom)
+		}
+	}
+	if !found {
+		t.Errorf("agent token env is not set")
+	}
+
+	if err := agents.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientSet.CoreV1().Secrets("cattle-system").Get(context.Background(), agents.getSecretName(), metav1.GetOptions{}); err == nil {
+		t.Errorf("secret is left after the cleanup")
+	}
+}
+
+func TestRandomToken(t *testing.T) {
+	a, err := utils.RandomToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := utils.RandomToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 64 || a == b {
+		t.Errorf("RandomToken() = %q, %q, want distinct 256-bit tokens", a, b)
+	}
+}
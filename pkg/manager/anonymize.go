@@ -0,0 +1,251 @@
+package manager
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/rancher/support-bundle-kit/pkg/redact"
+)
+
+// sniffLen is the number of bytes checked to tell text from binary content
+const sniffLen = 8000
+
+// anonymizeBundle replaces hostnames, IP addresses and usernames in the
+// content and the file names of the working directory, including the content
+// of node bundles. The mapping table is written next to the bundle file, never
+// into the bundle.
+func (m *SupportBundleManager) anonymizeBundle() error {
+	errLog, err := os.OpenFile(m.getErrorLogPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "fail to open bundle generation log")
+	}
+	defer errLog.Close()
+
+	if m.anonymizer.HostnameCount() == 0 {
+		logrus.Warn("no hostnames are learned, hostnames are not anonymized")
+		fmt.Fprintf(errLog, "Support Bundle: no hostnames are learned, hostnames are not anonymized\n")
+	}
+
+	var paths []string
+	err = filepath.Walk(m.getWorkingDir(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			fmt.Fprintf(errLog, "Support Bundle: failed to anonymize %v: %v\n", path, err)
+			return nil
+		}
+		if path == m.getErrorLogPath() {
+			// still written to, it's anonymized at last
+			return nil
+		}
+		paths = append(paths, path)
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if filepath.Ext(path) == ".zip" {
+			err = anonymizeZipFile(path, m.anonymizer)
+		} else {
+			err = anonymizeFile(path, m.anonymizer)
+		}
+		if err != nil {
+			fmt.Fprintf(errLog, "Support Bundle: failed to anonymize %v: %v\n", path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// rename the deepest paths first, so parent directories are still in place
+	sort.Slice(paths, func(i, j int) bool {
+		return strings.Count(paths[i], string(filepath.Separator)) > strings.Count(paths[j], string(filepath.Separator))
+	})
+	for _, path := range paths {
+		if path == m.getWorkingDir() {
+			continue
+		}
+		name := m.anonymizer.AnonymizeString(filepath.Base(path))
+		if name == filepath.Base(path) {
+			continue
+		}
+		if err := os.Rename(path, filepath.Join(filepath.Dir(path), name)); err != nil {
+			fmt.Fprintf(errLog, "Support Bundle: failed to anonymize %v: %v\n", path, err)
+		}
+	}
+
+	mappingFile := filepath.Join(m.OutputDir, strings.TrimSuffix(m.bundleFileName, m.format.Extension())+".mapping.yaml")
+	encodeToYAMLFile(m.anonymizer.Mapping(), mappingFile, errLog)
+	logrus.Infof("anonymization mapping is written to %s", mappingFile)
+
+	errLog.Close()
+	if err := anonymizeFile(m.getErrorLogPath(), m.anonymizer); err != nil {
+		logrus.Errorf("fail to anonymize %s: %v", m.getErrorLogPath(), err)
+	}
+	return nil
+}
+
+// learnHostnames registers the names of the nodes with the anonymizer. Nodes
+// are listed directly, so hostnames are anonymized even if the resource
+// filters leave out nodes.
+func (m *SupportBundleManager) learnHostnames(errLog io.Writer) {
+	nodes, err := m.k8s.GetNodesListByLabels("")
+	if err != nil {
+		logrus.Warnf("fail to list nodes, hostnames may not be anonymized: %v", err)
+		fmt.Fprintf(errLog, "Support Bundle: failed to list nodes, hostnames may not be anonymized: %v\n", err)
+		return
+	}
+	var hostnames []string
+	for _, node := range nodes.Items {
+		hostnames = append(hostnames, node.Name)
+		for _, addr := range node.Status.Addresses {
+			if addr.Type == corev1.NodeHostName {
+				hostnames = append(hostnames, addr.Address)
+			}
+		}
+	}
+	m.anonymizer.AddHostnames(hostnames...)
+}
+
+// isText tells if the content looks like text. Binary content is left as is.
+func isText(head []byte) bool {
+	return !bytes.Contains(head, []byte{0})
+}
+
+// anonymizeStream copies src to dst line by line and anonymizes each line.
+func anonymizeStream(dst io.Writer, src *bufio.Reader, anonymizer *redact.Anonymizer) error {
+	for {
+		line, err := src.ReadString('\n')
+		if len(line) > 0 {
+			if _, werr := io.WriteString(dst, anonymizer.AnonymizeString(line)); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func anonymizeFile(path string, anonymizer *redact.Anonymizer) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	reader := bufio.NewReaderSize(in, sniffLen)
+	head, err := reader.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if !isText(head) {
+		return nil
+	}
+
+	out, err := createTempLike(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	if err := anonymizeStream(out, reader, anonymizer); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), path)
+}
+
+// createTempLike creates a temporary file next to path with the same mode, to
+// replace path once it's written.
+func createTempLike(path string) (*os.File, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".anonymize-")
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(info.Mode()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// anonymizeZipFile rewrites a zip file, e.g., a node bundle, with anonymized
+// entry names and text content.
+func anonymizeZipFile(path string, anonymizer *redact.Anonymizer) error {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	out, err := createTempLike(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	w := zip.NewWriter(out)
+	for _, f := range r.File {
+		if err := anonymizeZipEntry(w, f, anonymizer); err != nil {
+			return errors.Wrapf(err, "fail to anonymize entry %s", f.Name)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), path)
+}
+
+func anonymizeZipEntry(w *zip.Writer, f *zip.File, anonymizer *redact.Anonymizer) error {
+	header := f.FileHeader
+	header.Name = anonymizer.AnonymizeString(f.Name)
+	entry, err := w.CreateHeader(&header)
+	if err != nil {
+		return err
+	}
+	if f.FileInfo().IsDir() {
+		return nil
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	reader := bufio.NewReaderSize(rc, sniffLen)
+	head, err := reader.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if !isText(head) {
+		_, err = io.Copy(entry, reader)
+		return err
+	}
+	return anonymizeStream(entry, reader, anonymizer)
+}
@@ -0,0 +1,102 @@
+package manager
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+
+	"github.com/rancher/support-bundle-kit/pkg/utils"
+)
+
+// authorizeUser allows a request only if its bearer token is valid and the
+// user of the token is allowed to perform AuthVerb on the support bundle by
+// cluster RBAC, e.g., get supportbundles.harvesterhci.io.
+func (s *HttpServer) authorizeUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		auth := req.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			utils.HttpResponseError(w, http.StatusUnauthorized, errors.New("bearer token is not provided"))
+			return
+		}
+
+		user, err := s.manager.authenticate(strings.TrimPrefix(auth, "Bearer "))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			utils.HttpResponseError(w, http.StatusUnauthorized, err)
+			return
+		}
+
+		allowed, err := s.manager.authorize(user)
+		if err != nil {
+			utils.HttpResponseError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !allowed {
+			utils.HttpResponseError(w, http.StatusForbidden, fmt.Errorf("user %s is not allowed to %s %s", user.Username, s.manager.AuthVerb, s.manager.getAuthResource()))
+			return
+		}
+		logrus.Debugf("user %s is authorized to %s %s", user.Username, req.Method, req.URL.Path)
+		next.ServeHTTP(w, req)
+	})
+}
+
+func (m *SupportBundleManager) getAuthResource() string {
+	if m.AuthGroup == "" {
+		return m.AuthResource
+	}
+	return m.AuthResource + "." + m.AuthGroup
+}
+
+// authenticate validates a bearer token with the TokenReview API
+func (m *SupportBundleManager) authenticate(token string) (*authenticationv1.UserInfo, error) {
+	review, err := m.k8s.CreateTokenReview(&authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token: token,
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to review token")
+	}
+	if !review.Status.Authenticated {
+		if review.Status.Error != "" {
+			return nil, fmt.Errorf("invalid token: %s", review.Status.Error)
+		}
+		return nil, errors.New("invalid token")
+	}
+	return &review.Status.User, nil
+}
+
+// authorize checks with a SubjectAccessReview whether the user is allowed to
+// access the support bundle
+func (m *SupportBundleManager) authorize(user *authenticationv1.UserInfo) (bool, error) {
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+
+	review, err := m.k8s.CreateSubjectAccessReview(&authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: m.PodNamespace,
+				Verb:      m.AuthVerb,
+				Group:     m.AuthGroup,
+				Resource:  m.AuthResource,
+				Name:      m.BundleName,
+			},
+			User:   user.Username,
+			Groups: user.Groups,
+			UID:    user.UID,
+			Extra:  extra,
+		},
+	})
+	if err != nil {
+		return false, errors.Wrap(err, "fail to review access")
+	}
+	return review.Status.Allowed, nil
+}
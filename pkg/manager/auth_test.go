@@ -0,0 +1,140 @@
+package manager
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newAuthClientSet returns a fake clientset that authenticates the tokens of
+// users and allows the allowed users to get the bundle. Reviews are recorded
+// in sars.
+func newAuthClientSet(users map[string]authenticationv1.UserInfo, allowed map[string]bool, sars *[]authorizationv1.SubjectAccessReview) *fake.Clientset {
+	clientSet := fake.NewSimpleClientset()
+	clientSet.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if review.Spec.Token == "broken" {
+			return true, nil, errors.New("api server is down")
+		}
+		user, ok := users[review.Spec.Token]
+		review = review.DeepCopy()
+		review.Status.Authenticated = ok
+		review.Status.User = user
+		if !ok {
+			review.Status.Error = "token is expired"
+		}
+		return true, review, nil
+	})
+	clientSet.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		*sars = append(*sars, *review)
+		if review.Spec.User == "broken" {
+			return true, nil, errors.New("api server is down")
+		}
+		review = review.DeepCopy()
+		review.Status.Allowed = allowed[review.Spec.User]
+		return true, review, nil
+	})
+	return clientSet
+}
+
+func TestAuthorizeUser(t *testing.T) {
+	users := map[string]authenticationv1.UserInfo{
+		"admin-token":  {Username: "admin", Groups: []string{"system:masters"}, Extra: map[string]authenticationv1.ExtraValue{"scope": {"all"}}},
+		"viewer-token": {Username: "viewer"},
+		"broken-token": {Username: "broken"},
+	}
+	allowed := map[string]bool{"admin": true}
+
+	tests := []struct {
+		name     string
+		auth     string
+		path     string
+		wantCode int
+	}{
+		{name: "allowed user", auth: "Bearer admin-token", path: "/status", wantCode: http.StatusOK},
+		{name: "allowed user bundle", auth: "Bearer admin-token", path: "/bundle", wantCode: http.StatusNotFound},
+		{name: "no token", path: "/status", wantCode: http.StatusUnauthorized},
+		{name: "basic auth", auth: "Basic YWRtaW46YWRtaW4=", path: "/status", wantCode: http.StatusUnauthorized},
+		{name: "invalid token", auth: "Bearer expired-token", path: "/status", wantCode: http.StatusUnauthorized},
+		{name: "token review fails", auth: "Bearer broken", path: "/status", wantCode: http.StatusUnauthorized},
+		{name: "forbidden user", auth: "Bearer viewer-token", path: "/bundle", wantCode: http.StatusForbidden},
+		{name: "access review fails", auth: "Bearer broken-token", path: "/status", wantCode: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sars []authorizationv1.SubjectAccessReview
+			m := newTestManagerForClientSet(t, newAuthClientSet(users, allowed, &sars))
+			m.Auth = true
+			m.AuthVerb = "get"
+			m.AuthResource = "supportbundles"
+			m.AuthGroup = "harvesterhci.io"
+			m.bundleFileName = "supportbundle_test.zip"
+			s := &HttpServer{context: m.context, manager: m}
+			handler := s.newRouter(m)
+
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body.String())
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("unauthorized response doesn't ask for a bearer token")
+			}
+		})
+	}
+}
+
+func TestAuthorizeReviewsBundleAccess(t *testing.T) {
+	users := map[string]authenticationv1.UserInfo{
+		"admin-token": {
+			Username: "admin",
+			UID:      "admin-uid",
+			Groups:   []string{"system:masters"},
+			Extra:    map[string]authenticationv1.ExtraValue{"scope": {"all"}},
+		},
+	}
+	var sars []authorizationv1.SubjectAccessReview
+	m := newTestManagerForClientSet(t, newAuthClientSet(users, map[string]bool{"admin": true}, &sars))
+	m.AuthVerb = "get"
+	m.AuthResource = "supportbundles"
+	m.AuthGroup = "harvesterhci.io"
+
+	user, err := m.authenticate("admin-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := m.authorize(user)
+	if err != nil || !ok {
+		t.Fatalf("authorize() = %v, %v, want allowed", ok, err)
+	}
+
+	if len(sars) != 1 {
+		t.Fatalf("%d access reviews, want 1", len(sars))
+	}
+	spec := sars[0].Spec
+	want := authorizationv1.ResourceAttributes{
+		Namespace: "cattle-system",
+		Verb:      "get",
+		Group:     "harvesterhci.io",
+		Resource:  "supportbundles",
+		Name:      "sb-test",
+	}
+	if spec.ResourceAttributes == nil || *spec.ResourceAttributes != want {
+		t.Errorf("resource attributes = %+v, want %+v", spec.ResourceAttributes, want)
+	}
+	if spec.User != "admin" || spec.UID != "admin-uid" || len(spec.Groups) != 1 || spec.Extra["scope"][0] != "all" {
+		t.Errorf("access review user = %+v, want the reviewed token's user", spec)
+	}
+}
@@ -2,14 +2,14 @@ package client
 
 import (
 	"context"
-	"fmt"
-	"io"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/Jeffail/gabs/v2"
 	"github.com/sirupsen/logrus"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/rest"
@@ -21,8 +21,27 @@ const (
 )
 
 type DiscoveryClient struct {
-	Context         context.Context
+	Context context.Context
+	// Filter selects the collected resources, all resources are collected
+	// if it's nil
+	Filter *ResourceFilter
+	// FetchWorkers and EncodeWorkers are the number of workers of Collect
+	FetchWorkers  int
+	EncodeWorkers int
+	// ClusterWideListThreshold is the number of namespaces from which each
+	// resource is listed once in all namespaces instead of once per
+	// namespace, 0 disables it
+	ClusterWideListThreshold int
+	// PageSize is the number of objects fetched per list request, lists
+	// are fetched at once if it's 0
+	PageSize        int64
 	discoveryClient *discovery.DiscoveryClient
+	skipped         skippedResources
+	timings         resourceTimings
+
+	// resourceLists caches the discovered resources for the run
+	resourceLists     []*metav1.APIResourceList
+	resourceListsLock sync.Mutex
 }
 
 func NewDiscoveryClient(ctx context.Context, config *rest.Config) (*DiscoveryClient, error) {
@@ -41,6 +60,36 @@ func NewDiscoveryClient(ctx context.Context, config *rest.Config) (*DiscoveryCli
 	}, nil
 }
 
+// ServerGroupNames returns the names of the API groups served by the cluster
+func (dc *DiscoveryClient) ServerGroupNames() ([]string, error) {
+	groups, err := dc.discoveryClient.ServerGroups()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(groups.Groups))
+	for _, group := range groups.Groups {
+		names = append(names, group.Name)
+	}
+	return names, nil
+}
+
+// SkippedResources returns the resources left out by the filter so far
+func (dc *DiscoveryClient) SkippedResources() []SkippedResource {
+	return dc.skipped.list()
+}
+
+func (dc *DiscoveryClient) allows(gv schema.GroupVersion, resource string, namespaced bool) bool {
+	if dc.Filter.Allows(gv, resource) {
+		return true
+	}
+	dc.skipped.add(SkippedResource{
+		GroupVersion: gv.String(),
+		Resource:     resource,
+		Namespaced:   namespaced,
+	})
+	return false
+}
+
 func toObj(b []byte, groupVersion, kind string) (interface{}, error) {
 
 	re := regexp.MustCompile(`("[a-zA-Z]+":)(null,)`)
@@ -81,74 +130,58 @@ func toObj(b []byte, groupVersion, kind string) (interface{}, error) {
 	return jsonParsed.Data(), nil
 }
 
-// Get all the namespaced resources for a given namespace
-func (dc *DiscoveryClient) ResourcesForNamespace(namespace string, errLog io.Writer) (map[string]interface{}, error) {
-	objs := make(map[string]interface{})
-
-	lists, err := dc.discoveryClient.ServerPreferredResources()
+// NamespacedRequests returns the list requests of all namespaced resources
+// in the namespaces
+func (dc *DiscoveryClient) NamespacedRequests(namespaces []string) ([]ResourceRequest, error) {
+	resources, err := dc.preferredResources(true)
 	if err != nil {
 		return nil, err
 	}
-
-	for _, list := range lists {
-		if len(list.APIResources) == 0 {
-			continue
-		}
-		gv, err := schema.ParseGroupVersion(list.GroupVersion)
-		if err != nil {
-			continue
+	if dc.ClusterWideListThreshold > 0 && len(namespaces) >= dc.ClusterWideListThreshold {
+		for i := range resources {
+			resources[i].Namespaces = namespaces
 		}
+		return resources, nil
+	}
 
-		for _, resource := range list.APIResources {
-			if !resource.Namespaced {
-				continue
-			}
-
-			// I would like to build the URL with rest client
-			// methods, but I was not able to.  It might be
-			// possible if a new rest client is created each
-			// time with the GroupVersion
-			prefix := "apis"
-			if gv.String() == "v1" {
-				prefix = "api"
-			}
-			url := fmt.Sprintf("/%s/%s/namespaces/%s/%s", prefix, gv.String(), namespace, resource.Name)
-
-			result := dc.discoveryClient.RESTClient().Get().AbsPath(url).Do(dc.Context)
-
-			// It is likely that errors can occur.
-			if result.Error() != nil {
-				logrus.Tracef("Failed to get %s: %v", url, result.Error())
-				fmt.Fprintf(errLog, "Failed to get %s: %v\n", url, result.Error())
-				continue
-			}
-
-			// This produces a byte array of json.
-			b, err := result.Raw()
-
-			if err == nil {
-				obj, err := toObj(b, gv.String(), resource.Kind)
-				if err != nil {
-					return nil, err
-				}
-				objs[gv.String()+"/"+resource.Name] = obj
-			}
+	requests := make([]ResourceRequest, 0, len(resources)*len(namespaces))
+	for _, namespace := range namespaces {
+		for _, r := range resources {
+			r.Namespace = namespace
+			requests = append(requests, r)
 		}
 	}
+	return requests, nil
+}
 
-	return objs, nil
-
+// ClusterRequests returns the list requests of the cluster level resources
+func (dc *DiscoveryClient) ClusterRequests() ([]ResourceRequest, error) {
+	return dc.preferredResources(false)
 }
 
-// Get the cluster level resources
-func (dc *DiscoveryClient) ResourcesForCluster(errLog io.Writer) (map[string]interface{}, error) {
-	objs := make(map[string]interface{})
+// serverPreferredResources discovers the resources once per run
+func (dc *DiscoveryClient) serverPreferredResources() ([]*metav1.APIResourceList, error) {
+	dc.resourceListsLock.Lock()
+	defer dc.resourceListsLock.Unlock()
+	if dc.resourceLists == nil {
+		lists, err := dc.discoveryClient.ServerPreferredResources()
+		if err != nil {
+			return nil, err
+		}
+		dc.resourceLists = lists
+	}
+	return dc.resourceLists, nil
+}
 
-	lists, err := dc.discoveryClient.ServerPreferredResources()
+// preferredResources returns the preferred version of each resource type of
+// the scope allowed by the filter
+func (dc *DiscoveryClient) preferredResources(namespaced bool) ([]ResourceRequest, error) {
+	lists, err := dc.serverPreferredResources()
 	if err != nil {
 		return nil, err
 	}
 
+	var requests []ResourceRequest
 	for _, list := range lists {
 		if len(list.APIResources) == 0 {
 			continue
@@ -159,36 +192,18 @@ func (dc *DiscoveryClient) ResourcesForCluster(errLog io.Writer) (map[string]int
 		}
 
 		for _, resource := range list.APIResources {
-			if resource.Namespaced {
+			if resource.Namespaced != namespaced {
 				continue
 			}
-
-			prefix := "apis"
-			if gv.String() == "v1" {
-				prefix = "api"
-			}
-			url := fmt.Sprintf("/%s/%s/%s", prefix, gv.String(), resource.Name)
-
-			result := dc.discoveryClient.RESTClient().Get().AbsPath(url).Do(dc.Context)
-
-			// It is likely that errors can occur.
-			if result.Error() != nil {
-				logrus.Tracef("Failed to get %s: %v", url, result.Error())
-				fmt.Fprintf(errLog, "Failed to get %s: %v\n", url, result.Error())
+			if !dc.allows(gv, resource.Name, namespaced) {
 				continue
 			}
-
-			b, err := result.Raw()
-
-			if err == nil {
-				obj, err := toObj(b, gv.String(), resource.Kind)
-				if err != nil {
-					return nil, err
-				}
-				objs[gv.String()+"/"+resource.Name] = obj
-			}
+			requests = append(requests, ResourceRequest{
+				GroupVersion: gv,
+				Resource:     resource.Name,
+				Kind:         resource.Kind,
+			})
 		}
 	}
-
-	return objs, nil
+	return requests, nil
 }
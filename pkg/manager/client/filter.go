@@ -0,0 +1,144 @@
+package client
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ResourceFilter selects the resources collected by the discovery client.
+// Rules are written as group/resource or group/version/resource, the core
+// group is written as core, and each part may contain wildcards, e.g.,
+// *.cattle.io/* or core/v1/events. A rule of a single part matches the
+// resource in any group, e.g., leases.
+type ResourceFilter struct {
+	include []resourceRule
+	exclude []resourceRule
+}
+
+type resourceRule struct {
+	group    string
+	version  string
+	resource string
+}
+
+// SkippedResource is a resource left out by the filter
+type SkippedResource struct {
+	GroupVersion string `json:"groupVersion" yaml:"groupVersion"`
+	Resource     string `json:"resource" yaml:"resource"`
+	Namespaced   bool   `json:"namespaced" yaml:"namespaced"`
+}
+
+// NewResourceFilter parses the include and exclude rules. A resource is
+// collected if it matches any include rule, or there are none, and it
+// matches no exclude rule.
+func NewResourceFilter(include, exclude []string) (*ResourceFilter, error) {
+	f := &ResourceFilter{}
+	var err error
+	if f.include, err = parseResourceRules(include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = parseResourceRules(exclude); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func parseResourceRules(rules []string) ([]resourceRule, error) {
+	parsed := make([]resourceRule, 0, len(rules))
+	for _, rule := range rules {
+		parts := strings.Split(rule, "/")
+		var r resourceRule
+		switch len(parts) {
+		case 1:
+			r = resourceRule{group: "*", version: "*", resource: parts[0]}
+		case 2:
+			r = resourceRule{group: parts[0], version: "*", resource: parts[1]}
+		case 3:
+			r = resourceRule{group: parts[0], version: parts[1], resource: parts[2]}
+		default:
+			return nil, fmt.Errorf("invalid resource rule %q, must be group/resource or group/version/resource", rule)
+		}
+		for _, part := range []string{r.group, r.version, r.resource} {
+			if part == "" {
+				return nil, fmt.Errorf("invalid resource rule %q, empty part", rule)
+			}
+			if _, err := path.Match(part, ""); err != nil {
+				return nil, fmt.Errorf("invalid resource rule %q: %v", rule, err)
+			}
+		}
+		parsed = append(parsed, r)
+	}
+	return parsed, nil
+}
+
+func (r *resourceRule) matches(gv schema.GroupVersion, resource string) bool {
+	group := gv.Group
+	if group == "" {
+		group = "core"
+	}
+	return matchPart(r.group, group) && matchPart(r.version, gv.Version) && matchPart(r.resource, resource)
+}
+
+func matchPart(pattern, s string) bool {
+	matched, _ := path.Match(pattern, s)
+	return matched
+}
+
+// Allows returns whether the resource is collected
+func (f *ResourceFilter) Allows(gv schema.GroupVersion, resource string) bool {
+	if f == nil {
+		return true
+	}
+	included := len(f.include) == 0
+	for i := range f.include {
+		if f.include[i].matches(gv, resource) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for i := range f.exclude {
+		if f.exclude[i].matches(gv, resource) {
+			return false
+		}
+	}
+	return true
+}
+
+// skippedResources records the resources left out by the filter once
+type skippedResources struct {
+	lock      sync.Mutex
+	resources map[SkippedResource]bool
+}
+
+func (s *skippedResources) add(r SkippedResource) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.resources == nil {
+		s.resources = make(map[SkippedResource]bool)
+	}
+	s.resources[r] = true
+}
+
+func (s *skippedResources) list() []SkippedResource {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	list := make([]SkippedResource, 0, len(s.resources))
+	for r := range s.resources {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].GroupVersion != list[j].GroupVersion {
+			return list[i].GroupVersion < list[j].GroupVersion
+		}
+		return list[i].Resource < list[j].Resource
+	})
+	return list
+}
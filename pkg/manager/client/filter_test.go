@@ -0,0 +1,80 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestResourceFilterAllows(t *testing.T) {
+	core := schema.GroupVersion{Version: "v1"}
+	apps := schema.GroupVersion{Group: "apps", Version: "v1"}
+	cattle := schema.GroupVersion{Group: "management.cattle.io", Version: "v3"}
+	events := schema.GroupVersion{Group: "events.k8s.io", Version: "v1"}
+
+	tests := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		gv       schema.GroupVersion
+		resource string
+		want     bool
+	}{
+		{name: "no rules", gv: apps, resource: "deployments", want: true},
+		{name: "core group", include: []string{"core/pods"}, gv: core, resource: "pods", want: true},
+		{name: "core group version", include: []string{"core/v1/pods"}, gv: core, resource: "pods", want: true},
+		{name: "other version", include: []string{"core/v2/pods"}, gv: core, resource: "pods", want: false},
+		{name: "not included", include: []string{"core/pods"}, gv: apps, resource: "deployments", want: false},
+		{name: "group wildcard", include: []string{"*.cattle.io/*"}, gv: cattle, resource: "clusters", want: true},
+		{name: "group wildcard other group", include: []string{"*.cattle.io/*"}, gv: apps, resource: "deployments", want: false},
+		{name: "resource in any group", exclude: []string{"events"}, gv: events, resource: "events", want: false},
+		{name: "resource in core group", exclude: []string{"events"}, gv: core, resource: "events", want: false},
+		{name: "exclude wins", include: []string{"core/*"}, exclude: []string{"core/v1/secrets"}, gv: core, resource: "secrets", want: false},
+		{name: "exclude other resource", include: []string{"core/*"}, exclude: []string{"core/v1/secrets"}, gv: core, resource: "configmaps", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewResourceFilter(tt.include, tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.Allows(tt.gv, tt.resource); got != tt.want {
+				t.Errorf("Allows(%s, %s) = %v, want %v", tt.gv, tt.resource, got, tt.want)
+			}
+		})
+	}
+
+	var f *ResourceFilter
+	if !f.Allows(core, "pods") {
+		t.Errorf("nil filter doesn't allow resources")
+	}
+}
+
+func TestNewResourceFilterInvalid(t *testing.T) {
+	for _, rule := range []string{"", "a/b/c/d", "core//pods", "core/[pods"} {
+		if _, err := NewResourceFilter([]string{rule}, nil); err == nil {
+			t.Errorf("NewResourceFilter(%q) succeeded, want an error", rule)
+		}
+		if _, err := NewResourceFilter(nil, []string{rule}); err == nil {
+			t.Errorf("NewResourceFilter(exclude %q) succeeded, want an error", rule)
+		}
+	}
+}
+
+func TestSkippedResources(t *testing.T) {
+	var s skippedResources
+	s.add(SkippedResource{GroupVersion: "v1", Resource: "secrets", Namespaced: true})
+	s.add(SkippedResource{GroupVersion: "apps/v1", Resource: "deployments", Namespaced: true})
+	s.add(SkippedResource{GroupVersion: "v1", Resource: "events", Namespaced: true})
+	s.add(SkippedResource{GroupVersion: "v1", Resource: "secrets", Namespaced: true})
+
+	want := []SkippedResource{
+		{GroupVersion: "apps/v1", Resource: "deployments", Namespaced: true},
+		{GroupVersion: "v1", Resource: "events", Namespaced: true},
+		{GroupVersion: "v1", Resource: "secrets", Namespaced: true},
+	}
+	if got := s.list(); !reflect.DeepEqual(got, want) {
+		t.Errorf("list() = %v, want %v", got, want)
+	}
+}
@@ -4,17 +4,20 @@ import (
 	"context"
 
 	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/version"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 )
 
 type KubernetesClient struct {
 	Context   context.Context
-	clientSet *kubernetes.Clientset
+	clientSet kubernetes.Interface
 }
 
 func NewKubernetesClient(ctx context.Context, config *rest.Config) (*KubernetesClient, error) {
@@ -22,16 +25,26 @@ func NewKubernetesClient(ctx context.Context, config *rest.Config) (*KubernetesC
 	if err != nil {
 		return nil, err
 	}
+	return NewKubernetesClientForClientSet(ctx, clientSet), nil
+}
+
+// NewKubernetesClientForClientSet wraps a clientset, e.g., a fake clientset in
+// tests
+func NewKubernetesClientForClientSet(ctx context.Context, clientSet kubernetes.Interface) *KubernetesClient {
 	return &KubernetesClient{
 		Context:   ctx,
 		clientSet: clientSet,
-	}, nil
+	}
 }
 
 func (k *KubernetesClient) GetNamespace(namespace string) (*corev1.Namespace, error) {
 	return k.clientSet.CoreV1().Namespaces().Get(k.Context, namespace, metav1.GetOptions{})
 }
 
+func (k *KubernetesClient) GetAllNamespacesList() (*corev1.NamespaceList, error) {
+	return k.clientSet.CoreV1().Namespaces().List(k.Context, metav1.ListOptions{})
+}
+
 func (k *KubernetesClient) GetKubernetesVersion() (*version.Info, error) {
 	return k.clientSet.Discovery().ServerVersion()
 }
@@ -51,6 +64,37 @@ func (k *KubernetesClient) GetPodContainerLogRequest(namespace, podName, contain
 	})
 }
 
+func (k *KubernetesClient) GetPodContainerPreviousLogRequest(namespace, podName, containerName string) *rest.Request {
+	return k.clientSet.CoreV1().Pods(namespace).GetLogs(podName, &corev1.PodLogOptions{
+		Container:  containerName,
+		Timestamps: true,
+		Previous:   true,
+	})
+}
+
+// NewPodInformer returns an informer of pods in a namespace matching the label
+// selector
+func (k *KubernetesClient) NewPodInformer(namespace string, labels string) cache.SharedIndexInformer {
+	lw := cache.NewFilteredListWatchFromClient(k.clientSet.CoreV1().RESTClient(), "pods", namespace, func(options *metav1.ListOptions) {
+		options.LabelSelector = labels
+	})
+	return cache.NewSharedIndexInformer(lw, &corev1.Pod{}, 0, cache.Indexers{})
+}
+
+// NewNodeInformer returns an informer of nodes matching the label selector
+func (k *KubernetesClient) NewNodeInformer(labels string) cache.SharedIndexInformer {
+	lw := cache.NewFilteredListWatchFromClient(k.clientSet.CoreV1().RESTClient(), "nodes", metav1.NamespaceAll, func(options *metav1.ListOptions) {
+		options.LabelSelector = labels
+	})
+	return cache.NewSharedIndexInformer(lw, &corev1.Node{}, 0, cache.Indexers{})
+}
+
+// GetNodeProxyRequest returns a request to the kubelet of a node through the
+// API server node proxy, e.g., path /stats/summary
+func (k *KubernetesClient) GetNodeProxyRequest(nodeName, path string) *rest.Request {
+	return k.clientSet.CoreV1().RESTClient().Get().Resource("nodes").Name(nodeName).SubResource("proxy").Suffix(path)
+}
+
 func (k *KubernetesClient) GetAllServicesList(namespace string) (runtime.Object, error) {
 	return k.clientSet.CoreV1().Services(namespace).List(k.Context, metav1.ListOptions{})
 }
@@ -75,6 +119,26 @@ func (k *KubernetesClient) DeleteDaemonSets(namespace, name string) error {
 	return k.clientSet.AppsV1().DaemonSets(namespace).Delete(k.Context, name, metav1.DeleteOptions{})
 }
 
+func (k *KubernetesClient) GetConfigMap(namespace, name string) (*corev1.ConfigMap, error) {
+	return k.clientSet.CoreV1().ConfigMaps(namespace).Get(k.Context, name, metav1.GetOptions{})
+}
+
+func (k *KubernetesClient) CreateSecret(namespace string, secret *corev1.Secret) (*corev1.Secret, error) {
+	return k.clientSet.CoreV1().Secrets(namespace).Create(k.Context, secret, metav1.CreateOptions{})
+}
+
+func (k *KubernetesClient) DeleteSecret(namespace, name string) error {
+	return k.clientSet.CoreV1().Secrets(namespace).Delete(k.Context, name, metav1.DeleteOptions{})
+}
+
+func (k *KubernetesClient) CreateTokenReview(review *authenticationv1.TokenReview) (*authenticationv1.TokenReview, error) {
+	return k.clientSet.AuthenticationV1().TokenReviews().Create(k.Context, review, metav1.CreateOptions{})
+}
+
+func (k *KubernetesClient) CreateSubjectAccessReview(review *authorizationv1.SubjectAccessReview) (*authorizationv1.SubjectAccessReview, error) {
+	return k.clientSet.AuthorizationV1().SubjectAccessReviews().Create(k.Context, review, metav1.CreateOptions{})
+}
+
 func (k *KubernetesClient) GetAllStatefulSetsList(namespace string) (runtime.Object, error) {
 	return k.clientSet.AppsV1().StatefulSets(namespace).List(k.Context, metav1.ListOptions{})
 }
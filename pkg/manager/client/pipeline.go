@@ -0,0 +1,371 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	DefaultFetchWorkers  = 8
+	DefaultEncodeWorkers = 2
+	// DefaultPageSize is the number of objects fetched per list request
+	DefaultPageSize = 500
+	// DefaultClusterWideListThreshold is the number of namespaces from which
+	// resources are listed in all namespaces at once
+	DefaultClusterWideListThreshold = 10
+)
+
+// ResourceRequest is the list request of a resource type in a namespace, or
+// in the cluster scope if Namespace is empty. If Namespaces is set, the
+// resource is listed in all namespaces at once and the list is split into the
+// lists of these namespaces.
+type ResourceRequest struct {
+	GroupVersion schema.GroupVersion
+	Resource     string
+	Kind         string
+	Namespace    string
+	Namespaces   []string
+}
+
+// Name is the name of the list in the bundle, e.g., apps/v1/deployments
+func (r *ResourceRequest) Name() string {
+	return r.GroupVersion.String() + "/" + r.Resource
+}
+
+func (r *ResourceRequest) URL() string {
+	// I would like to build the URL with rest client
+	// methods, but I was not able to.  It might be
+	// possible if a new rest client is created each
+	// time with the GroupVersion
+	prefix := "apis"
+	if r.GroupVersion.String() == "v1" {
+		prefix = "api"
+	}
+	if r.Namespace == "" {
+		return fmt.Sprintf("/%s/%s/%s", prefix, r.GroupVersion.String(), r.Resource)
+	}
+	return fmt.Sprintf("/%s/%s/namespaces/%s/%s", prefix, r.GroupVersion.String(), r.Namespace, r.Resource)
+}
+
+// ResourceTiming is how long a list request took
+type ResourceTiming struct {
+	URL      string `json:"url" yaml:"url"`
+	Page     int    `json:"page" yaml:"page"`
+	Duration string `json:"duration" yaml:"duration"`
+	Size     int    `json:"size" yaml:"size"`
+	Error    string `json:"error,omitempty" yaml:"error,omitempty"`
+
+	duration time.Duration
+}
+
+// ResourcePage is a page of a list. The pages of a list are encoded in order,
+// pages of different lists concurrently.
+type ResourcePage struct {
+	Request ResourceRequest
+	// List is a list object with the items of the page, the continue token is
+	// removed from its metadata
+	List map[string]interface{}
+	// First and Last are set on the first and the last page of the list
+	First bool
+	Last  bool
+	// Written is the number of items of the list on the previous pages
+	Written int
+}
+
+// Items returns the items of the page
+func (p *ResourcePage) Items() []interface{} {
+	items, _ := p.List["items"].([]interface{})
+	return items
+}
+
+// ResourceEncoder writes a page of a list to the bundle. It's called
+// concurrently by the encode workers.
+type ResourceEncoder func(page *ResourcePage)
+
+type encodeJob struct {
+	page *ResourcePage
+	done *sync.WaitGroup
+}
+
+// Collect fetches the resources of the requests and passes them to encode.
+// Lists are fetched page by page by FetchWorkers workers, which feed
+// EncodeWorkers workers through a bounded queue. A worker fetches the next
+// page of a list while the previous one is encoded, so memory use depends on
+// PageSize and the number of workers, not on the size of the lists. Collect
+// stops early and returns the error of the context if it's cancelled. Failed
+// requests are written to errLog.
+func (dc *DiscoveryClient) Collect(requests []ResourceRequest, encode ResourceEncoder, errLog io.Writer) error {
+	fetchWorkers := dc.FetchWorkers
+	if fetchWorkers <= 0 {
+		fetchWorkers = DefaultFetchWorkers
+	}
+	encodeWorkers := dc.EncodeWorkers
+	if encodeWorkers <= 0 {
+		encodeWorkers = DefaultEncodeWorkers
+	}
+	errLog = &syncWriter{w: errLog}
+
+	reqCh := make(chan ResourceRequest)
+	jobCh := make(chan encodeJob, encodeWorkers)
+
+	var fetchWg, encodeWg sync.WaitGroup
+	for i := 0; i < fetchWorkers; i++ {
+		fetchWg.Add(1)
+		go func() {
+			defer fetchWg.Done()
+			for req := range reqCh {
+				if !dc.fetch(req, errLog, dc.newEmitter(jobCh)) {
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < encodeWorkers; i++ {
+		encodeWg.Add(1)
+		go func() {
+			defer encodeWg.Done()
+			for job := range jobCh {
+				encode(job.page)
+				job.done.Done()
+			}
+		}()
+	}
+
+dispatch:
+	for _, req := range requests {
+		select {
+		case reqCh <- req:
+		case <-dc.Context.Done():
+			break dispatch
+		}
+	}
+	close(reqCh)
+	fetchWg.Wait()
+	close(jobCh)
+	encodeWg.Wait()
+
+	return dc.Context.Err()
+}
+
+// newEmitter returns a function queueing the pages fetched at once for the
+// encode workers. It waits for the pages queued before to be encoded first,
+// which keeps the pages of a list in order. It returns false if the context
+// is cancelled.
+func (dc *DiscoveryClient) newEmitter(jobCh chan<- encodeJob) func([]*ResourcePage) bool {
+	var pending *sync.WaitGroup
+	return func(pages []*ResourcePage) bool {
+		if pending != nil {
+			pending.Wait()
+		}
+		pending = &sync.WaitGroup{}
+		for _, page := range pages {
+			pending.Add(1)
+			select {
+			case jobCh <- encodeJob{page: page, done: pending}:
+			case <-dc.Context.Done():
+				pending.Done()
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// fetch pages through the list of a request and passes the pages to emit. A
+// list of all namespaces is split by namespace, if it's forbidden the
+// namespaces are listed one by one instead. It returns false if emit is
+// cancelled.
+func (dc *DiscoveryClient) fetch(req ResourceRequest, errLog io.Writer, emit func([]*ResourcePage) bool) bool {
+	// written counts the items of each list emitted so far
+	written := make(map[string]int)
+	token := ""
+	for page := 1; ; page++ {
+		list, next, err := dc.getPage(req, token, page)
+		if err != nil && page == 1 && len(req.Namespaces) > 0 && apierrors.IsForbidden(err) {
+			logrus.Debugf("listing %s per namespace: %v", req.URL(), err)
+			for _, namespace := range req.Namespaces {
+				nsReq := req
+				nsReq.Namespace = namespace
+				nsReq.Namespaces = nil
+				if !dc.fetch(nsReq, errLog, emit) {
+					return false
+				}
+			}
+			return true
+		}
+		// It is likely that errors can occur.
+		if err != nil {
+			if page == 1 {
+				logrus.Tracef("Failed to get %s: %v", req.URL(), err)
+				fmt.Fprintf(errLog, "Failed to get %s: %v\n", req.URL(), err)
+				return true
+			}
+			logrus.Tracef("Failed to get %s page %d: %v", req.URL(), page, err)
+			fmt.Fprintf(errLog, "Failed to get %s page %d, the list is incomplete: %v\n", req.URL(), page, err)
+			// close the lists with the items fetched so far
+			list = map[string]interface{}{"items": []interface{}{}}
+			next = ""
+		}
+
+		pages := splitPage(req, list, written, page == 1, next == "")
+		if !emit(pages) {
+			return false
+		}
+		if next == "" {
+			return true
+		}
+		token = next
+	}
+}
+
+// getPage gets a page of a list, converts it and records the timing of the
+// request. The continue token of the next page is returned, it's empty on the
+// last page.
+func (dc *DiscoveryClient) getPage(req ResourceRequest, token string, page int) (map[string]interface{}, string, error) {
+	url := req.URL()
+	request := dc.discoveryClient.RESTClient().Get().AbsPath(url)
+	if dc.PageSize > 0 {
+		request = request.Param("limit", strconv.FormatInt(dc.PageSize, 10))
+	}
+	if token != "" {
+		request = request.Param("continue", token)
+	}
+
+	start := time.Now()
+	b, err := request.Do(dc.Context).Raw()
+	timing := ResourceTiming{
+		URL:      url,
+		Page:     page,
+		Size:     len(b),
+		duration: time.Since(start),
+	}
+	timing.Duration = timing.duration.Round(time.Millisecond).String()
+	if err != nil {
+		timing.Error = err.Error()
+	}
+	dc.timings.add(timing)
+	if err != nil {
+		return nil, "", err
+	}
+
+	obj, err := toObj(b, req.GroupVersion.String(), req.Kind)
+	if err != nil {
+		return nil, "", err
+	}
+	list, ok := obj.(map[string]interface{})
+	if !ok {
+		return nil, "", fmt.Errorf("unexpected list %T", obj)
+	}
+
+	next := ""
+	if metadata, ok := list["metadata"].(map[string]interface{}); ok {
+		next, _ = metadata["continue"].(string)
+		delete(metadata, "continue")
+		delete(metadata, "remainingItemCount")
+	}
+	return list, next, nil
+}
+
+// splitPage returns the pages of the lists of a request. A page of all
+// namespaces is split into a page per requested namespace. Namespaces without
+// items on the page are left out, except on the first and the last page, so
+// every namespace gets a complete list.
+func splitPage(req ResourceRequest, list map[string]interface{}, written map[string]int, first, last bool) []*ResourcePage {
+	newPage := func(namespace string, items []interface{}) *ResourcePage {
+		nsList := make(map[string]interface{}, len(list))
+		for k, v := range list {
+			nsList[k] = v
+		}
+		nsList["items"] = items
+
+		nsReq := req
+		if len(req.Namespaces) > 0 {
+			nsReq.Namespace = namespace
+			nsReq.Namespaces = nil
+		}
+		page := &ResourcePage{
+			Request: nsReq,
+			List:    nsList,
+			First:   first,
+			Last:    last,
+			Written: written[namespace],
+		}
+		written[namespace] += len(items)
+		return page
+	}
+
+	items, _ := list["items"].([]interface{})
+	if len(req.Namespaces) == 0 {
+		return []*ResourcePage{newPage("", items)}
+	}
+
+	byNamespace := make(map[string][]interface{}, len(req.Namespaces))
+	for _, namespace := range req.Namespaces {
+		byNamespace[namespace] = []interface{}{}
+	}
+	for _, item := range items {
+		obj, _ := item.(map[string]interface{})
+		metadata, _ := obj["metadata"].(map[string]interface{})
+		namespace, _ := metadata["namespace"].(string)
+		if _, ok := byNamespace[namespace]; ok {
+			byNamespace[namespace] = append(byNamespace[namespace], item)
+		}
+	}
+
+	pages := make([]*ResourcePage, 0, len(req.Namespaces))
+	for _, namespace := range req.Namespaces {
+		nsItems := byNamespace[namespace]
+		if len(nsItems) == 0 && !first && !last {
+			continue
+		}
+		pages = append(pages, newPage(namespace, nsItems))
+	}
+	return pages
+}
+
+// Timings returns the timings of the requests so far, the slowest first
+func (dc *DiscoveryClient) Timings() []ResourceTiming {
+	return dc.timings.list()
+}
+
+type resourceTimings struct {
+	lock    sync.Mutex
+	timings []ResourceTiming
+}
+
+func (t *resourceTimings) add(timing ResourceTiming) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.timings = append(t.timings, timing)
+}
+
+func (t *resourceTimings) list() []ResourceTiming {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	list := make([]ResourceTiming, len(t.timings))
+	copy(list, t.timings)
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].duration > list[j].duration
+	})
+	return list
+}
+
+// syncWriter serializes writes of the workers
+type syncWriter struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.w.Write(p)
+}
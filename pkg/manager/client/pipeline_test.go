@@ -0,0 +1,511 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+)
+
+// fakeAPIServer serves lists of pods, paged by limit and continue. Paths in
+// forbidden get a 403, paths in failing fail from the given page on.
+type fakeAPIServer struct {
+	pods      map[string][]string
+	forbidden map[string]bool
+	failing   map[string]int
+
+	lock     sync.Mutex
+	requests []string
+}
+
+func (s *fakeAPIServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// the timeout of the discovery client is left out
+	query := req.URL.Query()
+	query.Del("timeout")
+	request := req.URL.Path
+	if len(query) > 0 {
+		request += "?" + query.Encode()
+	}
+	s.lock.Lock()
+	s.requests = append(s.requests, request)
+	s.lock.Unlock()
+
+	path := req.URL.Path
+	if s.forbidden[path] {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_ = json.NewEncoder(w).Encode(metav1.Status{
+			TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+			Status:   metav1.StatusFailure,
+			Reason:   metav1.StatusReasonForbidden,
+			Code:     http.StatusForbidden,
+			Message:  "pods is forbidden",
+		})
+		return
+	}
+
+	var names []string
+	switch {
+	case path == "/api/v1/pods":
+		for _, ns := range []string{"ns-a", "ns-b", "ns-c"} {
+			names = append(names, s.pods[ns]...)
+		}
+	case strings.HasPrefix(path, "/api/v1/namespaces/") && strings.HasSuffix(path, "/pods"):
+		names = s.pods[strings.Split(path, "/")[4]]
+	default:
+		http.NotFound(w, req)
+		return
+	}
+
+	offset, _ := strconv.Atoi(req.URL.Query().Get("continue"))
+	if page, ok := s.failing[path]; ok && offset > 0 && page > 1 {
+		http.Error(w, "etcd is gone", http.StatusInternalServerError)
+		return
+	}
+	end := len(names)
+	if limit, _ := strconv.Atoi(req.URL.Query().Get("limit")); limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+	items := []map[string]interface{}{}
+	for _, name := range names[offset:end] {
+		ns := strings.SplitN(name, "/", 2)[0]
+		items = append(items, map[string]interface{}{
+			"metadata": map[string]interface{}{"namespace": ns, "name": name},
+		})
+	}
+	metadata := map[string]interface{}{"resourceVersion": "1"}
+	if end < len(names) {
+		metadata["continue"] = strconv.Itoa(end)
+		metadata["remainingItemCount"] = len(names) - end
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"kind":       "PodList",
+		"apiVersion": "v1",
+		"metadata":   metadata,
+		"items":      items,
+	})
+}
+
+func newFakeDiscoveryClient(t *testing.T, server *fakeAPIServer) *DiscoveryClient {
+	srv := httptest.NewServer(server)
+	t.Cleanup(srv.Close)
+	dc, err := NewDiscoveryClient(context.Background(), &rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dc
+}
+
+func newPodNames(ns string, n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("%s/pod-%d", ns, i)
+	}
+	return names
+}
+
+func podRequest(namespace string, namespaces ...string) ResourceRequest {
+	return ResourceRequest{
+		GroupVersion: schema.GroupVersion{Version: "v1"},
+		Resource:     "pods",
+		Kind:         "Pod",
+		Namespace:    namespace,
+		Namespaces:   namespaces,
+	}
+}
+
+// pageSummary is what the tests check of an encoded page
+type pageSummary struct {
+	Namespace string
+	Items     []string
+	First     bool
+	Last      bool
+	Written   int
+}
+
+// collectPages runs Collect and returns the pages of each list in the order
+// they are encoded
+func collectPages(t *testing.T, dc *DiscoveryClient, requests ...ResourceRequest) (map[string][]pageSummary, string) {
+	var lock sync.Mutex
+	pages := make(map[string][]pageSummary)
+	encode := func(page *ResourcePage) {
+		// slow encoders give later pages a chance to overtake
+		time.Sleep(time.Millisecond)
+		summary := pageSummary{
+			Namespace: page.Request.Namespace,
+			First:     page.First,
+			Last:      page.Last,
+			Written:   page.Written,
+		}
+		for _, item := range page.Items() {
+			metadata := item.(map[string]interface{})["metadata"].(map[string]interface{})
+			summary.Items = append(summary.Items, metadata["name"].(string))
+		}
+		if metadata, ok := page.List["metadata"].(map[string]interface{}); ok {
+			if _, ok := metadata["continue"]; ok {
+				t.Errorf("continue token is left in page of %s", page.Request.URL())
+			}
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		pages[page.Request.URL()] = append(pages[page.Request.URL()], summary)
+	}
+	errLog := &bytes.Buffer{}
+	if err := dc.Collect(requests, encode, errLog); err != nil {
+		t.Fatal(err)
+	}
+	return pages, errLog.String()
+}
+
+func TestCollectPagination(t *testing.T) {
+	server := &fakeAPIServer{pods: map[string][]string{"ns-a": newPodNames("ns-a", 7)}}
+	tests := []struct {
+		name         string
+		pageSize     int64
+		want         []pageSummary
+		wantRequests []string
+	}{
+		{
+			name:     "pages",
+			pageSize: 3,
+			want: []pageSummary{
+				{Namespace: "ns-a", Items: newPodNames("ns-a", 7)[0:3], First: true},
+				{Namespace: "ns-a", Items: newPodNames("ns-a", 7)[3:6], Written: 3},
+				{Namespace: "ns-a", Items: newPodNames("ns-a", 7)[6:7], Written: 6, Last: true},
+			},
+			wantRequests: []string{
+				"/api/v1/namespaces/ns-a/pods?limit=3",
+				"/api/v1/namespaces/ns-a/pods?continue=3&limit=3",
+				"/api/v1/namespaces/ns-a/pods?continue=6&limit=3",
+			},
+		},
+		{
+			name:     "last page is full",
+			pageSize: 7,
+			want: []pageSummary{
+				{Namespace: "ns-a", Items: newPodNames("ns-a", 7), First: true, Last: true},
+			},
+			wantRequests: []string{"/api/v1/namespaces/ns-a/pods?limit=7"},
+		},
+		{
+			name: "no paging",
+			want: []pageSummary{
+				{Namespace: "ns-a", Items: newPodNames("ns-a", 7), First: true, Last: true},
+			},
+			wantRequests: []string{"/api/v1/namespaces/ns-a/pods"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.requests = nil
+			dc := newFakeDiscoveryClient(t, server)
+			dc.PageSize = tt.pageSize
+
+			pages, errLog := collectPages(t, dc, podRequest("ns-a"))
+			if errLog != "" {
+				t.Errorf("error log = %q", errLog)
+			}
+			if got := pages["/api/v1/namespaces/ns-a/pods"]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pages = %+v, want %+v", got, tt.want)
+			}
+			if !reflect.DeepEqual(server.requests, tt.wantRequests) {
+				t.Errorf("requests = %v, want %v", server.requests, tt.wantRequests)
+			}
+			timings := dc.Timings()
+			if len(timings) != len(tt.wantRequests) {
+				t.Errorf("timings = %+v, want one per request", timings)
+			}
+		})
+	}
+}
+
+func TestCollectPageError(t *testing.T) {
+	server := &fakeAPIServer{
+		pods:    map[string][]string{"ns-a": newPodNames("ns-a", 5)},
+		failing: map[string]int{"/api/v1/namespaces/ns-a/pods": 2},
+	}
+	dc := newFakeDiscoveryClient(t, server)
+	dc.PageSize = 2
+
+	pages, errLog := collectPages(t, dc, podRequest("ns-a"))
+	// the list is closed with the items of the first page
+	want := []pageSummary{
+		{Namespace: "ns-a", Items: newPodNames("ns-a", 5)[0:2], First: true},
+		{Namespace: "ns-a", Written: 2, Last: true},
+	}
+	if got := pages["/api/v1/namespaces/ns-a/pods"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("pages = %+v, want %+v", got, want)
+	}
+	if !strings.Contains(errLog, "Failed to get /api/v1/namespaces/ns-a/pods page 2, the list is incomplete") {
+		t.Errorf("error log = %q", errLog)
+	}
+}
+
+func TestCollectPagesInOrder(t *testing.T) {
+	server := &fakeAPIServer{pods: map[string][]string{}}
+	var requests []ResourceRequest
+	for i := 0; i < 6; i++ {
+		ns := fmt.Sprintf("ns-%d", i)
+		server.pods[ns] = newPodNames(ns, 20)
+		requests = append(requests, podRequest(ns))
+	}
+	dc := newFakeDiscoveryClient(t, server)
+	dc.PageSize = 2
+	dc.FetchWorkers = 3
+	dc.EncodeWorkers = 4
+
+	// the pages of a list are encoded in order, even with more encode
+	// workers than lists
+	pages, _ := collectPages(t, dc, requests...)
+	for _, req := range requests {
+		list := pages[req.URL()]
+		if len(list) != 10 {
+			t.Fatalf("%s has %d pages, want 10", req.URL(), len(list))
+		}
+		var items []string
+		for i, page := range list {
+			if page.Written != 2*i || page.First != (i == 0) || page.Last != (i == 9) {
+				t.Errorf("page %d of %s = %+v, out of order", i, req.URL(), page)
+			}
+			items = append(items, page.Items...)
+		}
+		if !reflect.DeepEqual(items, server.pods[req.Namespace]) {
+			t.Errorf("items of %s = %v, want %v", req.URL(), items, server.pods[req.Namespace])
+		}
+	}
+}
+
+func TestNewEmitterWaitsForQueuedPages(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dc := &DiscoveryClient{Context: ctx}
+	jobCh := make(chan encodeJob, 10)
+	emit := dc.newEmitter(jobCh)
+
+	first := []*ResourcePage{{Written: 0}, {Written: 1}}
+	if !emit(first) {
+		t.Fatal("emit() = false")
+	}
+	emitted := make(chan bool)
+	go func() {
+		emitted <- emit([]*ResourcePage{{Written: 2}})
+	}()
+
+	// the second page isn't queued while the first ones are encoded, there
+	// is room for it in the queue
+	jobs := []encodeJob{<-jobCh, <-jobCh}
+	jobs[0].done.Done()
+	select {
+	case <-emitted:
+		t.Fatal("pages are queued before the previous pages are encoded")
+	case <-time.After(50 * time.Millisecond):
+	}
+	jobs[1].done.Done()
+	if !<-emitted {
+		t.Fatal("emit() = false")
+	}
+	if job := <-jobCh; job.page.Written != 2 {
+		t.Errorf("queued page = %+v, want the second emit", job.page)
+	}
+	for i, job := range jobs {
+		if job.page != first[i] {
+			t.Errorf("job %d = %+v, want %+v", i, job.page, first[i])
+		}
+	}
+}
+
+func TestNewEmitterCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	dc := &DiscoveryClient{Context: ctx}
+	// nobody encodes, the queue is full after the first page
+	jobCh := make(chan encodeJob, 1)
+	emit := dc.newEmitter(jobCh)
+
+	emitted := make(chan bool)
+	go func() {
+		emitted <- emit([]*ResourcePage{{}, {}})
+	}()
+	cancel()
+	select {
+	case ok := <-emitted:
+		if ok {
+			t.Errorf("emit() = true after the context is cancelled")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("emit() is blocked after the context is cancelled")
+	}
+}
+
+func TestCollectAllNamespaces(t *testing.T) {
+	pods := map[string][]string{
+		"ns-a": newPodNames("ns-a", 3),
+		"ns-b": nil,
+		"ns-c": newPodNames("ns-c", 2),
+	}
+	tests := []struct {
+		name         string
+		forbidden    []string
+		want         map[string][]pageSummary
+		wantRequests []string
+		wantErrLog   string
+	}{
+		{
+			name: "split by namespace",
+			want: map[string][]pageSummary{
+				"/api/v1/namespaces/ns-a/pods": {
+					{Namespace: "ns-a", Items: pods["ns-a"][0:2], First: true},
+					{Namespace: "ns-a", Items: pods["ns-a"][2:3], Written: 2},
+					{Namespace: "ns-a", Last: true, Written: 3},
+				},
+				// namespaces without items still get a complete list
+				"/api/v1/namespaces/ns-b/pods": {
+					{Namespace: "ns-b", First: true},
+					{Namespace: "ns-b", Last: true},
+				},
+				"/api/v1/namespaces/ns-c/pods": {
+					{Namespace: "ns-c", First: true},
+					{Namespace: "ns-c", Items: pods["ns-c"][0:1]},
+					{Namespace: "ns-c", Items: pods["ns-c"][1:2], Last: true, Written: 1},
+				},
+			},
+			wantRequests: []string{
+				"/api/v1/pods?limit=2",
+				"/api/v1/pods?continue=2&limit=2",
+				"/api/v1/pods?continue=4&limit=2",
+			},
+		},
+		{
+			name:      "forbidden in all namespaces",
+			forbidden: []string{"/api/v1/pods"},
+			want: map[string][]pageSummary{
+				"/api/v1/namespaces/ns-a/pods": {
+					{Namespace: "ns-a", Items: pods["ns-a"][0:2], First: true},
+					{Namespace: "ns-a", Items: pods["ns-a"][2:3], Last: true, Written: 2},
+				},
+				"/api/v1/namespaces/ns-b/pods": {
+					{Namespace: "ns-b", First: true, Last: true},
+				},
+				"/api/v1/namespaces/ns-c/pods": {
+					{Namespace: "ns-c", Items: pods["ns-c"], First: true, Last: true},
+				},
+			},
+			wantRequests: []string{
+				"/api/v1/pods?limit=2",
+				"/api/v1/namespaces/ns-a/pods?limit=2",
+				"/api/v1/namespaces/ns-a/pods?continue=2&limit=2",
+				"/api/v1/namespaces/ns-b/pods?limit=2",
+				"/api/v1/namespaces/ns-c/pods?limit=2",
+			},
+		},
+		{
+			name:      "forbidden in a namespace",
+			forbidden: []string{"/api/v1/pods", "/api/v1/namespaces/ns-b/pods"},
+			want: map[string][]pageSummary{
+				"/api/v1/namespaces/ns-a/pods": {
+					{Namespace: "ns-a", Items: pods["ns-a"][0:2], First: true},
+					{Namespace: "ns-a", Items: pods["ns-a"][2:3], Last: true, Written: 2},
+				},
+				"/api/v1/namespaces/ns-c/pods": {
+					{Namespace: "ns-c", Items: pods["ns-c"], First: true, Last: true},
+				},
+			},
+			wantRequests: []string{
+				"/api/v1/pods?limit=2",
+				"/api/v1/namespaces/ns-a/pods?limit=2",
+				"/api/v1/namespaces/ns-a/pods?continue=2&limit=2",
+				"/api/v1/namespaces/ns-b/pods?limit=2",
+				"/api/v1/namespaces/ns-c/pods?limit=2",
+			},
+			wantErrLog: "Failed to get /api/v1/namespaces/ns-b/pods",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &fakeAPIServer{pods: pods, forbidden: map[string]bool{}}
+			for _, path := range tt.forbidden {
+				server.forbidden[path] = true
+			}
+			dc := newFakeDiscoveryClient(t, server)
+			dc.PageSize = 2
+			// a single fetch worker keeps the requests in order
+			dc.FetchWorkers = 1
+
+			got, errLog := collectPages(t, dc, podRequest("", "ns-a", "ns-b", "ns-c"))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pages = %+v, want %+v", got, tt.want)
+			}
+			if !reflect.DeepEqual(server.requests, tt.wantRequests) {
+				t.Errorf("requests = %v, want %v", server.requests, tt.wantRequests)
+			}
+			if tt.wantErrLog == "" && errLog != "" || !strings.Contains(errLog, tt.wantErrLog) {
+				t.Errorf("error log = %q, want %q", errLog, tt.wantErrLog)
+			}
+			if strings.Contains(errLog, "/api/v1/pods") {
+				t.Errorf("forbidden list of all namespaces is logged as an error: %q", errLog)
+			}
+		})
+	}
+}
+
+func TestSplitPage(t *testing.T) {
+	item := func(namespace, name string) interface{} {
+		return map[string]interface{}{"metadata": map[string]interface{}{"namespace": namespace, "name": name}}
+	}
+	req := podRequest("", "ns-a", "ns-b")
+	list := map[string]interface{}{
+		"kind":     "List",
+		"metadata": map[string]interface{}{"resourceVersion": "1"},
+		"items":    []interface{}{item("ns-a", "a1"), item("ns-other", "o1"), item("ns-a", "a2")},
+	}
+	tests := []struct {
+		name        string
+		first, last bool
+		written     map[string]int
+		// items per namespace of the pages, in order
+		want        map[string]int
+		wantWritten map[string]int
+	}{
+		{name: "first page", first: true, written: map[string]int{}, want: map[string]int{"ns-a": 2, "ns-b": 0}, wantWritten: map[string]int{"ns-a": 2, "ns-b": 0}},
+		{name: "middle page", written: map[string]int{"ns-a": 3}, want: map[string]int{"ns-a": 2}, wantWritten: map[string]int{"ns-a": 5}},
+		{name: "last page", last: true, written: map[string]int{"ns-a": 3, "ns-b": 1}, want: map[string]int{"ns-a": 2, "ns-b": 0}, wantWritten: map[string]int{"ns-a": 5, "ns-b": 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := make(map[string]int)
+			for k, v := range tt.written {
+				before[k] = v
+			}
+			pages := splitPage(req, list, tt.written, tt.first, tt.last)
+			got := make(map[string]int)
+			for _, page := range pages {
+				ns := page.Request.Namespace
+				got[ns] = len(page.Items())
+				if page.Request.Namespaces != nil || page.First != tt.first || page.Last != tt.last || page.Written != before[ns] {
+					t.Errorf("page of %s = %+v", ns, page)
+				}
+				if page.List["kind"] != "List" {
+					t.Errorf("page of %s lost the fields of the list: %v", ns, page.List)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("items per namespace = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(tt.written, tt.wantWritten) {
+				t.Errorf("written = %v, want %v", tt.written, tt.wantWritten)
+			}
+		})
+	}
+}
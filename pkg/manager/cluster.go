@@ -1,12 +1,14 @@
 package manager
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -15,7 +17,8 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	k8sjson "k8s.io/apimachinery/pkg/runtime/serializer/json"
 
-	"github.com/rancher/support-bundle-kit/pkg/utils"
+	"github.com/rancher/support-bundle-kit/pkg/manager/client"
+	"github.com/rancher/support-bundle-kit/pkg/redact"
 )
 
 type Cluster struct {
@@ -49,22 +52,30 @@ func (c *Cluster) GenerateClusterBundle(bundleDir string) (string, error) {
 		BundleVersion:        BundleVersion,
 		KubernetesVersion:    kubeVersion.GitVersion,
 		ProjectNamespaceUUID: string(namespace.UID),
-		BundleCreatedAt:      utils.Now(),
+		BundleCreatedAt:      c.sbm.createdAt.Format(time.RFC3339),
 		IssueURL:             sb.Spec.IssueURL,
 		IssueDescription:     sb.Spec.Description,
+		Profiles:             splitList(c.sbm.Profiles),
+		Namespaces:           c.sbm.getTargetNamespaces(),
+		MissingAPIGroups:     c.getMissingAPIGroups(),
 	}
 
-	bundleName := fmt.Sprintf("supportbundle_%s_%s.zip",
+	bundleName := fmt.Sprintf("supportbundle_%s_%s%s",
 		bundleMeta.ProjectNamespaceUUID,
-		strings.Replace(bundleMeta.BundleCreatedAt, ":", "-", -1))
+		strings.Replace(bundleMeta.BundleCreatedAt, ":", "-", -1),
+		c.sbm.format.Extension())
 
-	errLog, err := os.Create(filepath.Join(bundleDir, "bundleGenerationError.log"))
+	errLog, err := os.Create(c.sbm.getErrorLogPath())
 	if err != nil {
 		logrus.Errorf("Failed to create bundle generation log")
 		return "", err
 	}
 	defer errLog.Close()
 
+	if c.sbm.anonymizer != nil {
+		c.sbm.learnHostnames(errLog)
+	}
+
 	metaFile := filepath.Join(bundleDir, "metadata.yaml")
 	encodeToYAMLFile(bundleMeta, metaFile, errLog)
 
@@ -74,59 +85,88 @@ func (c *Cluster) GenerateClusterBundle(bundleDir string) (string, error) {
 	logsDir := filepath.Join(bundleDir, "logs")
 	c.generateSupportBundleLogs(logsDir, errLog)
 
+	externalDir := filepath.Join(bundleDir, "external")
+	c.generateExternalBundles(externalDir, c.sbm.profile.ExternalBundles, errLog)
+
 	return bundleName, nil
 }
 
+// getMissingAPIGroups returns the API groups of the selected profiles that are
+// not served by the cluster
+func (c *Cluster) getMissingAPIGroups() []string {
+	groups, err := c.sbm.discovery.ServerGroupNames()
+	if err != nil {
+		logrus.Warnf("fail to get API groups: %v", err)
+		return nil
+	}
+	served := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		served[group] = true
+	}
+
+	var missing []string
+	for _, group := range c.sbm.profile.APIGroups {
+		if !served[group] {
+			logrus.Warnf("API group %s of the selected profiles is not found", group)
+			missing = append(missing, group)
+		}
+	}
+	return missing
+}
+
 func (c *Cluster) generateSupportBundleYAMLs(yamlsDir string, errLog io.Writer) {
-	// Cluster scope
+	// Cluster scope. It's collected first, the anonymizer learns the names
+	// from it.
 	globalDir := filepath.Join(yamlsDir, "cluster")
 	c.generateDiscoveredClusterYAMLs(globalDir, errLog)
 
 	// Namespaced scope: all resources
-	namespaces := []string{"default", "kube-system", "cattle-system"}
-	namespaces = append(namespaces, c.sbm.Namespaces...)
-
-	done := make(map[string]struct{})
-	for _, namespace := range namespaces {
-		if _, ok := done[namespace]; ok {
-			continue
-		}
-
-		namespacedDir := filepath.Join(yamlsDir, "namespaced", namespace)
-		c.generateDiscoveredNamespacedYAMLs(namespace, namespacedDir, errLog)
+	namespacedDir := filepath.Join(yamlsDir, "namespaced")
+	c.generateDiscoveredNamespacedYAMLs(c.sbm.getTargetNamespaces(), namespacedDir, errLog)
 
-		done[namespace] = struct{}{}
+	if skipped := c.sbm.discovery.SkippedResources(); len(skipped) > 0 {
+		logrus.Infof("skipped %d resources by the resource filters", len(skipped))
+		encodeToYAMLFile(skipped, filepath.Join(yamlsDir, "skipped-resources.yaml"), errLog)
 	}
+	encodeToYAMLFile(c.sbm.discovery.Timings(), filepath.Join(yamlsDir, "request-timings.yaml"), errLog)
 }
 
 type NamespacedGetter func(string) (runtime.Object, error)
 
-func (c *Cluster) generateDiscoveredNamespacedYAMLs(namespace string, dir string, errLog io.Writer) {
-
-	objs, err := c.sbm.discovery.ResourcesForNamespace(namespace, errLog)
-
+func (c *Cluster) generateDiscoveredNamespacedYAMLs(namespaces []string, dir string, errLog io.Writer) {
+	requests, err := c.sbm.discovery.NamespacedRequests(namespaces)
 	if err != nil {
-		logrus.Error("Unable to fetch namespaced resources")
+		logrus.Errorf("Unable to fetch namespaced resources: %v", err)
 		return
 	}
 
-	for name, obj := range objs {
-		file := filepath.Join(dir, name+".yaml")
-		encodeToYAMLFile(obj, file, errLog)
+	err = c.sbm.discovery.Collect(requests, func(page *client.ResourcePage) {
+		c.sbm.redactor.Redact(page.List)
+		file := filepath.Join(dir, page.Request.Namespace, page.Request.Name()+".yaml")
+		encodePageToYAMLFile(page, file, errLog)
+	}, errLog)
+	if err != nil {
+		logrus.Errorf("Unable to fetch namespaced resources: %v", err)
 	}
 }
 
 func (c *Cluster) generateDiscoveredClusterYAMLs(dir string, errLog io.Writer) {
-	objs, err := c.sbm.discovery.ResourcesForCluster(errLog)
-
+	requests, err := c.sbm.discovery.ClusterRequests()
 	if err != nil {
-		logrus.Error("Unable to fetch cluster resources")
+		logrus.Errorf("Unable to fetch cluster resources: %v", err)
 		return
 	}
 
-	for name, obj := range objs {
-		file := filepath.Join(dir, name+".yaml")
-		encodeToYAMLFile(obj, file, errLog)
+	err = c.sbm.discovery.Collect(requests, func(page *client.ResourcePage) {
+		c.sbm.redactor.Redact(page.List)
+		if c.sbm.anonymizer != nil {
+			c.sbm.anonymizer.Learn(page.List)
+		}
+		file := filepath.Join(dir, page.Request.Name()+".yaml")
+		encodePageToYAMLFile(page, file, errLog)
+	}, errLog)
+	if err != nil {
+		logrus.Errorf("Unable to fetch cluster resources: %v", err)
 	}
 }
 
@@ -170,22 +210,83 @@ func encodeToYAMLFile(obj interface{}, path string, errLog io.Writer) {
 	}
 }
 
+// encodePageToYAMLFile appends a page of a list to its YAML file. The file is
+// written as the fields of the list followed by the items of each page, so a
+// list is never held in memory as a whole.
+func encodePageToYAMLFile(page *client.ResourcePage, path string, errLog io.Writer) {
+	var err error
+	defer func() {
+		if err != nil {
+			fmt.Fprintf(errLog, "Support Bundle: failed to generate %v: %v\n", path, err)
+		}
+	}()
+
+	flags := os.O_APPEND | os.O_WRONLY
+	if page.First {
+		if err = os.MkdirAll(filepath.Dir(path), os.FileMode(0755)); err != nil {
+			return
+		}
+		flags = os.O_CREATE | os.O_TRUNC | os.O_WRONLY
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+
+	if page.First {
+		header := make(map[string]interface{}, len(page.List))
+		for k, v := range page.List {
+			if k != "items" {
+				header[k] = v
+			}
+		}
+		if err = writeYAML(w, header); err != nil {
+			return
+		}
+	}
+
+	items := page.Items()
+	if len(items) > 0 {
+		if page.Written == 0 {
+			if _, err = w.WriteString("items:\n"); err != nil {
+				return
+			}
+		}
+		if err = writeYAML(w, items); err != nil {
+			return
+		}
+	} else if page.Last && page.Written == 0 {
+		if _, err = w.WriteString("items: []\n"); err != nil {
+			return
+		}
+	}
+	err = w.Flush()
+}
+
+func writeYAML(w io.Writer, obj interface{}) error {
+	b, err := yaml.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
 type GetRuntimeObjectListFunc func() (runtime.Object, error)
 
 func (c *Cluster) generateSupportBundleLogs(logsDir string, errLog io.Writer) {
-	namespaces := []string{"default", "kube-system", "cattle-system"}
-	namespaces = append(namespaces, c.sbm.Namespaces...)
-
-	for _, ns := range namespaces {
+	for _, ns := range c.sbm.getTargetNamespaces() {
 		list, err := c.sbm.k8s.GetAllPodsList(ns)
 		if err != nil {
-			fmt.Fprintf(errLog, "Support bundle: cannot get pod list: %v\n", err)
-			return
+			fmt.Fprintf(errLog, "Support bundle: cannot get pod list of namespace %s: %v\n", ns, err)
+			continue
 		}
 		podList, ok := list.(*corev1.PodList)
 		if !ok {
-			fmt.Fprintf(errLog, "BUG: Support bundle: didn't get pod list\n")
-			return
+			fmt.Fprintf(errLog, "BUG: Support bundle: didn't get pod list of namespace %s\n", ns)
+			continue
 		}
 		for _, pod := range podList.Items {
 			podName := pod.Name
@@ -199,14 +300,14 @@ func (c *Cluster) generateSupportBundleLogs(logsDir string, errLog io.Writer) {
 						podName, container.Name, err)
 					continue
 				}
-				streamLogToFile(stream, logFileName, errLog)
+				streamLogToFile(stream, logFileName, c.sbm.redactor, errLog)
 				stream.Close()
 			}
 		}
 	}
 }
 
-func streamLogToFile(logStream io.ReadCloser, path string, errLog io.Writer) {
+func streamLogToFile(logStream io.ReadCloser, path string, redactor *redact.Redactor, errLog io.Writer) {
 	var err error
 	defer func() {
 		if err != nil {
@@ -222,7 +323,7 @@ func streamLogToFile(logStream io.ReadCloser, path string, errLog io.Writer) {
 		return
 	}
 	defer f.Close()
-	_, err = io.Copy(f, logStream)
+	_, err = redactor.Copy(f, logStream)
 	if err != nil {
 		return
 	}
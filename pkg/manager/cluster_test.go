@@ -0,0 +1,175 @@
+package manager
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/rancher/support-bundle-kit/pkg/manager/client"
+	"github.com/rancher/support-bundle-kit/pkg/redact"
+)
+
+func TestGenerateSupportBundleLogs(t *testing.T) {
+	newPod := func(namespace, name string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+	}
+	clientSet := fake.NewSimpleClientset(newPod("ns-a", "pod-a"), newPod("ns-b", "pod-b"), newPod("ns-c", "pod-c"))
+	clientSet.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() == "ns-a" {
+			return true, nil, apierrors.NewForbidden(corev1.Resource("pods"), "", errors.New("denied"))
+		}
+		return false, nil, nil
+	})
+	m := newTestManagerForClientSet(t, clientSet)
+	m.redactor = redact.NewRedactor(redact.SecretModeMask, nil)
+	m.targetNamespaces = []string{"ns-a", "ns-b", "ns-c"}
+
+	logsDir := filepath.Join(m.getWorkingDir(), "logs")
+	errLog := &bytes.Buffer{}
+	NewCluster(m.context, m).generateSupportBundleLogs(logsDir, errLog)
+
+	// a namespace whose pods can't be listed doesn't stop the others
+	for _, ns := range []string{"ns-b", "ns-c"} {
+		path := filepath.Join(logsDir, ns, "pod-"+strings.TrimPrefix(ns, "ns-"), "app.log")
+		if b, err := ioutil.ReadFile(path); err != nil || len(b) == 0 {
+			t.Errorf("log of namespace %s is not collected: %v", ns, err)
+		}
+	}
+	if !strings.Contains(errLog.String(), "cannot get pod list of namespace ns-a") {
+		t.Errorf("error log = %q, want the failure of ns-a", errLog.String())
+	}
+}
+
+func TestEncodePageToYAMLFile(t *testing.T) {
+	header := map[string]interface{}{"apiVersion": "v1", "kind": "List", "metadata": map[string]interface{}{"resourceVersion": "1"}}
+	newPage := func(first, last bool, written int, names ...string) *client.ResourcePage {
+		list := map[string]interface{}{"items": []interface{}{}}
+		for k, v := range header {
+			list[k] = v
+		}
+		items := []interface{}{}
+		for _, name := range names {
+			items = append(items, map[string]interface{}{"metadata": map[string]interface{}{"name": name}})
+		}
+		list["items"] = items
+		return &client.ResourcePage{List: list, First: first, Last: last, Written: written}
+	}
+
+	tests := []struct {
+		name  string
+		pages []*client.ResourcePage
+		want  []string
+	}{
+		{
+			name:  "single page",
+			pages: []*client.ResourcePage{newPage(true, true, 0, "a", "b")},
+			want:  []string{"a", "b"},
+		},
+		{
+			name:  "empty list",
+			pages: []*client.ResourcePage{newPage(true, true, 0)},
+			want:  []string{},
+		},
+		{
+			name: "first, middle and last pages",
+			pages: []*client.ResourcePage{
+				newPage(true, false, 0, "a", "b"),
+				newPage(false, false, 2, "c", "d"),
+				newPage(false, true, 4, "e"),
+			},
+			want: []string{"a", "b", "c", "d", "e"},
+		},
+		{
+			name: "empty last page",
+			pages: []*client.ResourcePage{
+				newPage(true, false, 0, "a"),
+				newPage(false, true, 1),
+			},
+			want: []string{"a"},
+		},
+		{
+			name: "empty first page",
+			pages: []*client.ResourcePage{
+				newPage(true, false, 0),
+				newPage(false, false, 0, "a"),
+				newPage(false, true, 1, "b"),
+			},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "empty pages",
+			pages: []*client.ResourcePage{
+				newPage(true, false, 0),
+				newPage(false, true, 0),
+			},
+			want: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "encode")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, "v1", "pods.yaml")
+			errLog := &bytes.Buffer{}
+			for _, page := range tt.pages {
+				encodePageToYAMLFile(page, path, errLog)
+			}
+			if errLog.Len() > 0 {
+				t.Fatalf("error log = %q", errLog.String())
+			}
+
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the items key is written once, by the first page with items
+			// or by the last page of an empty list
+			if n := strings.Count(string(b), "items:"); n != 1 {
+				t.Fatalf("items key is written %d times:\n%s", n, b)
+			}
+			var list struct {
+				APIVersion string                 `yaml:"apiVersion"`
+				Kind       string                 `yaml:"kind"`
+				Metadata   map[string]interface{} `yaml:"metadata"`
+				Items      []struct {
+					Metadata struct {
+						Name string `yaml:"name"`
+					} `yaml:"metadata"`
+				} `yaml:"items"`
+			}
+			if err := yaml.UnmarshalStrict(b, &list); err != nil {
+				t.Fatalf("invalid YAML: %v\n%s", err, b)
+			}
+			if list.APIVersion != "v1" || list.Kind != "List" {
+				t.Errorf("list header = %s %s, want v1 List", list.APIVersion, list.Kind)
+			}
+			names := []string{}
+			for _, item := range list.Items {
+				names = append(names, item.Metadata.Name)
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("items = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
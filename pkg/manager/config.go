@@ -0,0 +1,170 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ConfigVersion is the version of the manager configuration file
+const ConfigVersion = "v1"
+
+// Config is the manager configuration file. Settings are overridden by
+// environment variables and flags, e.g.,
+//
+//	version: v1
+//	profiles: [harvester]
+//	namespaces: [harvester-system, longhorn-system]
+//	outputFormat: tar.zst
+//	collectors:
+//	  nodeSelector: node-role.kubernetes.io/worker=true
+//	  skipNotReadyNodes: true
+//	redaction:
+//	  secrets: mask
+//	  rules: /etc/support-bundle-kit/redaction-rules.yaml
+//	limits:
+//	  waitTimeout: 20m
+//	  maxNodeBundleSize: 2Gi
+//	agentTemplate:
+//	  tolerateAll: true
+//	upload:
+//	  targets:
+//	  - url: https://bundles.example.com/upload/
+type Config struct {
+	Version           string   `mapstructure:"version"`
+	Profiles          []string `mapstructure:"profiles"`
+	Namespaces        []string `mapstructure:"namespaces"`
+	NamespaceSelector string   `mapstructure:"namespaceSelector"`
+	AllNamespaces     bool     `mapstructure:"allNamespaces"`
+	OutputDir         string   `mapstructure:"outputDir"`
+	OutputFormat      string   `mapstructure:"outputFormat"`
+
+	Image struct {
+		Name       string `mapstructure:"name"`
+		PullPolicy string `mapstructure:"pullPolicy"`
+	} `mapstructure:"image"`
+
+	Collectors struct {
+		NodeSelector      string   `mapstructure:"nodeSelector"`
+		NodeNames         []string `mapstructure:"nodeNames"`
+		SkipNotReadyNodes bool     `mapstructure:"skipNotReadyNodes"`
+		NewNodes          string   `mapstructure:"newNodes"`
+		KubeletFallback   bool     `mapstructure:"kubeletFallback"`
+	} `mapstructure:"collectors"`
+
+	Resources struct {
+		Include []string `mapstructure:"include"`
+		Exclude []string `mapstructure:"exclude"`
+	} `mapstructure:"resources"`
+
+	Redaction struct {
+		Secrets        string   `mapstructure:"secrets"`
+		Rules          string   `mapstructure:"rules"`
+		Anonymize      bool     `mapstructure:"anonymize"`
+		AnonymizeUsers []string `mapstructure:"anonymizeUsers"`
+	} `mapstructure:"redaction"`
+
+	Limits struct {
+		WaitTimeout       time.Duration `mapstructure:"waitTimeout"`
+		MaxNodeBundleSize string        `mapstructure:"maxNodeBundleSize"`
+		AgentRestartLimit int32         `mapstructure:"agentRestartLimit"`
+		FetchWorkers      int           `mapstructure:"fetchWorkers"`
+		EncodeWorkers     int           `mapstructure:"encodeWorkers"`
+		// ClusterWideListThreshold is the number of namespaces from which
+		// resources are listed in all namespaces at once, 0 disables it
+		ClusterWideListThreshold int `mapstructure:"clusterWideListThreshold"`
+		// PageSize is the number of objects fetched per list request, 0
+		// fetches lists at once
+		PageSize int64 `mapstructure:"pageSize"`
+	} `mapstructure:"limits"`
+
+	Auth struct {
+		Enabled  bool   `mapstructure:"enabled"`
+		Verb     string `mapstructure:"verb"`
+		Resource string `mapstructure:"resource"`
+		Group    string `mapstructure:"group"`
+	} `mapstructure:"auth"`
+
+	TLS struct {
+		Enabled bool   `mapstructure:"enabled"`
+		Cert    string `mapstructure:"cert"`
+		Key     string `mapstructure:"key"`
+	} `mapstructure:"tls"`
+
+	// AgentTemplate is decoded as an AgentTemplate, it's kept raw since the
+	// Kubernetes types in it are only decoded correctly from JSON
+	AgentTemplate map[string]interface{} `mapstructure:"agentTemplate"`
+
+	Upload struct {
+		Targets []UploadTarget `mapstructure:"targets"`
+	} `mapstructure:"upload"`
+}
+
+// UploadTarget is where the bundle is uploaded with a HTTP PUT once it's ready
+type UploadTarget struct {
+	// URL of the bundle file, the bundle file name is appended if it ends with /
+	URL     string            `mapstructure:"url"`
+	Headers map[string]string `mapstructure:"headers"`
+	Timeout time.Duration     `mapstructure:"timeout"`
+}
+
+// Validate checks the parts of the configuration file that are not validated
+// by the manager itself
+func (c *Config) Validate() error {
+	if c.Version != ConfigVersion {
+		return fmt.Errorf("unsupported config version %q, expected %q", c.Version, ConfigVersion)
+	}
+	for i, target := range c.Upload.Targets {
+		if target.URL == "" {
+			return fmt.Errorf("upload.targets[%d].url is not specified", i)
+		}
+		if target.Timeout < 0 {
+			return fmt.Errorf("upload.targets[%d].timeout must not be negative", i)
+		}
+	}
+	if c.AgentTemplate != nil {
+		if _, err := c.GetAgentTemplate(); err != nil {
+			return fmt.Errorf("invalid agentTemplate: %v", err)
+		}
+	}
+	return nil
+}
+
+// GetAgentTemplate returns the agent template of the configuration file, nil
+// if it's not set
+func (c *Config) GetAgentTemplate() (*AgentTemplate, error) {
+	if c.AgentTemplate == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(toJSONValue(c.AgentTemplate))
+	if err != nil {
+		return nil, err
+	}
+	return parseAgentTemplate(data)
+}
+
+// toJSONValue converts the maps decoded from YAML, which may have interface{}
+// keys, to maps that can be encoded to JSON
+func toJSONValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = toJSONValue(value)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[key] = toJSONValue(value)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, value := range v {
+			l[i] = toJSONValue(value)
+		}
+		return l
+	}
+	return v
+}
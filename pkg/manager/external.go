@@ -0,0 +1,181 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rancher/support-bundle-kit/pkg/utils"
+)
+
+const (
+	ExternalBundleTypeHTTP     = "http"
+	ExternalBundleTypeLonghorn = "longhorn"
+
+	externalBundleTimeout = 30 * time.Minute
+)
+
+// externalBundlePollInterval is how often the progress of an external bundle
+// is checked, it's shortened by tests
+var externalBundlePollInterval = 5 * time.Second
+
+// ExternalBundle is a support bundle of another product included in the
+// bundle. The http type downloads URL with a GET request. The longhorn type
+// generates a bundle with the Longhorn manager API at URL.
+type ExternalBundle struct {
+	Name string
+	Type string
+	URL  string
+}
+
+// longhornSupportBundleInitiated is the response of creating a Longhorn
+// support bundle
+type longhornSupportBundleInitiated struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type longhornSupportBundle struct {
+	State              string `json:"state"`
+	ProgressPercentage int    `json:"progressPercentage"`
+	ErrorMessage       string `json:"errorMessage"`
+}
+
+// generateExternalBundles writes the external bundles into dir. A failed
+// external bundle doesn't fail the support bundle, it's recorded in the
+// generation error log.
+func (c *Cluster) generateExternalBundles(dir string, externals []ExternalBundle, errLog io.Writer) {
+	for _, external := range externals {
+		logrus.Infof("collecting external bundle %s", external.Name)
+		ctx, cancel := context.WithTimeout(c.sbm.context, externalBundleTimeout)
+		var err error
+		switch external.Type {
+		case ExternalBundleTypeHTTP:
+			err = downloadExternalBundle(ctx, external.URL, dir, external.Name)
+		case ExternalBundleTypeLonghorn:
+			err = c.generateLonghornBundle(ctx, external, dir)
+		default:
+			err = fmt.Errorf("unknown type %s", external.Type)
+		}
+		cancel()
+		if err != nil {
+			fmt.Fprintf(errLog, "Support Bundle: failed to collect external bundle %s: %v\n", external.Name, err)
+		}
+	}
+}
+
+// generateLonghornBundle asks the Longhorn manager to generate a support
+// bundle, waits for it and downloads it.
+func (c *Cluster) generateLonghornBundle(ctx context.Context, external ExternalBundle, dir string) error {
+	sb, err := c.sbm.state.GetSupportBundle(c.sbm.PodNamespace, c.sbm.BundleName)
+	if err != nil {
+		return errors.Wrap(err, "cannot get support bundle")
+	}
+	body, err := json.Marshal(map[string]string{
+		"issueURL":    sb.Spec.IssueURL,
+		"description": sb.Spec.Description,
+	})
+	if err != nil {
+		return err
+	}
+
+	baseURL := strings.TrimSuffix(external.URL, "/") + "/v1/supportbundles"
+	initiated := &longhornSupportBundleInitiated{}
+	if err := doJSON(ctx, http.MethodPost, baseURL, bytes.NewReader(body), initiated); err != nil {
+		return errors.Wrap(err, "fail to create Longhorn support bundle")
+	}
+
+	bundleURL := fmt.Sprintf("%s/%s/%s", baseURL, initiated.ID, initiated.Name)
+	ticker := time.NewTicker(externalBundlePollInterval)
+	defer ticker.Stop()
+	for {
+		status := &longhornSupportBundle{}
+		if err := doJSON(ctx, http.MethodGet, bundleURL, nil, status); err != nil {
+			return errors.Wrap(err, "fail to get Longhorn support bundle")
+		}
+		if status.State == "Error" {
+			return fmt.Errorf("Longhorn support bundle failed: %s", status.ErrorMessage)
+		}
+		if status.ProgressPercentage >= 100 {
+			break
+		}
+		logrus.Debugf("Longhorn support bundle progress: %d%%", status.ProgressPercentage)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return downloadExternalBundle(ctx, bundleURL+"/download", dir, external.Name)
+}
+
+func doJSON(ctx context.Context, method, url string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected response %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// downloadExternalBundle saves a bundle into dir with the file name of the
+// Content-Disposition header, or with name if there is none.
+func downloadExternalBundle(ctx context.Context, url, dir, name string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected response %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+
+	filename, err := utils.HttpGetDispositionFilename(resp.Header.Get("Content-Disposition"))
+	if err != nil {
+		filename = name
+	}
+	// the name comes from a server, keep it inside dir
+	filename = filepath.Base(filepath.Clean("/" + filename))
+	if filename == string(filepath.Separator) {
+		filename = name
+	}
+
+	if err := os.MkdirAll(dir, os.FileMode(0755)); err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(dir, filename))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, resp.Body)
+	return err
+}
@@ -0,0 +1,156 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLonghorn serves the support bundle API of the Longhorn manager. Each
+// status request returns the next status until the last one.
+type fakeLonghorn struct {
+	statuses    []longhornSupportBundle
+	disposition string
+
+	lock     sync.Mutex
+	polls    int
+	request  map[string]string
+	download bool
+}
+
+func (f *fakeLonghorn) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	switch {
+	case req.Method == http.MethodPost && req.URL.Path == "/v1/supportbundles":
+		_ = json.NewDecoder(req.Body).Decode(&f.request)
+		_ = json.NewEncoder(w).Encode(longhornSupportBundleInitiated{ID: "node-1", Name: "lh-bundle"})
+	case req.Method == http.MethodGet && req.URL.Path == "/v1/supportbundles/node-1/lh-bundle":
+		status := f.statuses[len(f.statuses)-1]
+		if f.polls < len(f.statuses) {
+			status = f.statuses[f.polls]
+		}
+		f.polls++
+		_ = json.NewEncoder(w).Encode(status)
+	case req.Method == http.MethodGet && req.URL.Path == "/v1/supportbundles/node-1/lh-bundle/download":
+		f.download = true
+		if f.disposition != "" {
+			w.Header().Set("Content-Disposition", f.disposition)
+		}
+		fmt.Fprint(w, "longhorn bundle")
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func TestGenerateLonghornBundle(t *testing.T) {
+	saved := externalBundlePollInterval
+	externalBundlePollInterval = time.Millisecond
+	defer func() { externalBundlePollInterval = saved }()
+
+	progress := []longhornSupportBundle{
+		{State: "InProgress", ProgressPercentage: 10},
+		{State: "InProgress", ProgressPercentage: 60},
+		{State: "ReadyForDownload", ProgressPercentage: 100},
+	}
+	tests := []struct {
+		name        string
+		statuses    []longhornSupportBundle
+		disposition string
+		wantFile    string
+		wantPolls   int
+		wantErr     string
+	}{
+		{
+			name:        "progress to 100",
+			statuses:    progress,
+			disposition: "attachment; filename=supportbundle_lh.zip",
+			wantFile:    "supportbundle_lh.zip",
+			wantPolls:   3,
+		},
+		{
+			name:     "no Content-Disposition",
+			statuses: progress[2:],
+			wantFile: "longhorn",
+		},
+		{
+			name:        "path in Content-Disposition",
+			statuses:    progress[2:],
+			disposition: `attachment; filename="../../etc/evil.zip"`,
+			wantFile:    "evil.zip",
+		},
+		{
+			name:        "only dots in Content-Disposition",
+			statuses:    progress[2:],
+			disposition: "attachment; filename=..",
+			wantFile:    "longhorn",
+		},
+		{
+			name:      "error state",
+			statuses:  []longhornSupportBundle{{State: "InProgress", ProgressPercentage: 10}, {State: "Error", ErrorMessage: "disk full"}},
+			wantPolls: 2,
+			wantErr:   "Longhorn support bundle failed: disk full",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			longhorn := &fakeLonghorn{statuses: tt.statuses, disposition: tt.disposition}
+			srv := httptest.NewServer(longhorn)
+			defer srv.Close()
+
+			m := newTestManager(t)
+			m.state = NewLocalStore(m.PodNamespace, m.BundleName)
+			dir := filepath.Join(m.getWorkingDir(), "external")
+			external := ExternalBundle{Name: "longhorn", Type: ExternalBundleTypeLonghorn, URL: srv.URL + "/"}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			err := NewCluster(m.context, m).generateLonghornBundle(ctx, external, dir)
+
+			if tt.wantPolls > 0 && longhorn.polls != tt.wantPolls {
+				t.Errorf("status is polled %d times, want %d", longhorn.polls, tt.wantPolls)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("generateLonghornBundle() = %v, want %q", err, tt.wantErr)
+				}
+				if longhorn.download {
+					t.Errorf("failed bundle is downloaded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := longhorn.request["issueURL"]; !ok {
+				t.Errorf("create request = %v, want the issue URL", longhorn.request)
+			}
+
+			files, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 1 || files[0].Name() != tt.wantFile {
+				t.Fatalf("files = %v, want only %s", files, tt.wantFile)
+			}
+			b, err := ioutil.ReadFile(filepath.Join(dir, tt.wantFile))
+			if err != nil || string(b) != "longhorn bundle" {
+				t.Errorf("bundle = %q, %v", b, err)
+			}
+			// nothing is written outside of the directory
+			if _, err := os.Stat(filepath.Join(m.OutputDir, "etc")); !os.IsNotExist(err) {
+				t.Errorf("bundle is written outside of %s", dir)
+			}
+		})
+	}
+}
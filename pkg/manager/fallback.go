@@ -0,0 +1,199 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rancher/support-bundle-kit/pkg/archive"
+	"github.com/rancher/support-bundle-kit/pkg/utils"
+)
+
+const (
+	// kubeletRequestTimeout is the timeout of a request to the kubelet proxy
+	kubeletRequestTimeout = time.Minute
+	// maxKubeletLogSize is the max size of a log file fetched from the kubelet
+	maxKubeletLogSize = 50 * 1024 * 1024
+)
+
+// kubeletLogLinkRegexp matches the links of the /logs/ directory listing
+var kubeletLogLinkRegexp = regexp.MustCompile(`<a href="([^"]+)">`)
+
+// requestFallback schedules a node for collection through the kubelet proxy
+func (m *SupportBundleManager) requestFallback(node string) {
+	if !m.KubeletFallback {
+		return
+	}
+	m.nodesLock.Lock()
+	defer m.nodesLock.Unlock()
+	m.fallbackNodes = append(m.fallbackNodes, node)
+}
+
+// collectFallbackBundles collects partial node bundles through the API server
+// node proxy from nodes that can't run the agent, e.g., cordoned, tainted or
+// not ready nodes whose kubelet is still reachable.
+func (m *SupportBundleManager) collectFallbackBundles() {
+	m.nodesLock.Lock()
+	nodes := m.fallbackNodes
+	m.fallbackNodes = nil
+	m.nodesLock.Unlock()
+
+	if len(nodes) == 0 {
+		return
+	}
+
+	errLog, err := os.OpenFile(m.getErrorLogPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		logrus.Errorf("fail to open bundle generation log: %v", err)
+		return
+	}
+	defer errLog.Close()
+
+	for _, node := range nodes {
+		logrus.Infof("collecting partial node bundle of %s through the kubelet proxy", node)
+		size, err := m.collectFallbackBundle(node)
+		if err != nil {
+			fmt.Fprintf(errLog, "Support Bundle: failed to collect node %s through the kubelet proxy: %v\n", node, err)
+			continue
+		}
+		fmt.Fprintf(errLog, "Support Bundle: node %s is collected through the kubelet proxy, the node bundle is partial\n", node)
+		m.status.SetNodePartial(node, size)
+	}
+}
+
+// collectFallbackBundle writes nodes/<node>.zip with the kubelet logs, stats
+// and configuration. It returns the size of the node bundle.
+func (m *SupportBundleManager) collectFallbackBundle(node string) (int64, error) {
+	tmpDir, err := ioutil.TempDir(m.OutputDir, ".node-fallback-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	bundleDir := filepath.Join(tmpDir, node)
+	if err := os.MkdirAll(bundleDir, os.FileMode(0755)); err != nil {
+		return 0, err
+	}
+	errLog, err := os.Create(filepath.Join(bundleDir, "bundleGenerationError.log"))
+	if err != nil {
+		return 0, err
+	}
+	defer errLog.Close()
+
+	fmt.Fprintf(errLog, "Support Bundle: PARTIAL node bundle, the agent didn't run on node %s. It's collected through the kubelet proxy.\n", node)
+
+	reachable := false
+	for _, p := range []string{"/stats/summary", "/configz"} {
+		dest := filepath.Join(bundleDir, "kubelet", strings.TrimPrefix(strings.ReplaceAll(p, "/", "-"), "-")+".json")
+		if _, err := m.fetchKubelet(node, p, dest, 0); err != nil {
+			fmt.Fprintf(errLog, "Support Bundle: failed to get %s: %v\n", p, err)
+			continue
+		}
+		reachable = true
+	}
+
+	logs, err := m.listKubeletLogs(node)
+	if err != nil {
+		fmt.Fprintf(errLog, "Support Bundle: failed to list /logs/: %v\n", err)
+	} else {
+		reachable = true
+	}
+	for _, name := range logs {
+		dest := filepath.Join(bundleDir, "logs", filepath.FromSlash(name))
+		truncated, err := m.fetchKubelet(node, "/logs/"+name, dest, maxKubeletLogSize)
+		if err != nil {
+			fmt.Fprintf(errLog, "Support Bundle: failed to get /logs/%s: %v\n", name, err)
+			continue
+		}
+		if truncated {
+			fmt.Fprintf(errLog, "Support Bundle: /logs/%s is truncated to the last %d bytes\n", name, maxKubeletLogSize)
+		}
+	}
+	if !reachable {
+		return 0, errors.New("kubelet is not reachable")
+	}
+
+	nodesDir := filepath.Join(m.getWorkingDir(), "nodes")
+	if err := os.MkdirAll(nodesDir, os.FileMode(0775)); err != nil {
+		return 0, err
+	}
+	nodeBundle := filepath.Join(nodesDir, node+".zip")
+	opts := archive.Options{
+		Prefix: node,
+		ErrLog: errLog,
+		Last:   []string{filepath.Base(errLog.Name())},
+	}
+	if err := archive.Create(nodeBundle, bundleDir, archive.FormatZip, opts); err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(nodeBundle)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// listKubeletLogs returns the files at the top of the /logs/ directory listing.
+// Subdirectories, e.g., pods and containers, are skipped since pod logs are
+// in the cluster bundle.
+func (m *SupportBundleManager) listKubeletLogs(node string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(m.context, kubeletRequestTimeout)
+	defer cancel()
+
+	body, err := m.k8s.GetNodeProxyRequest(node, "/logs/").DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, match := range kubeletLogLinkRegexp.FindAllStringSubmatch(string(body), -1) {
+		name, err := url.PathUnescape(match[1])
+		if err != nil || strings.HasSuffix(name, "/") || strings.Contains(name, "..") || path.IsAbs(name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// fetchKubelet writes the response of a kubelet proxy request to dest. Only
+// the last maxSize bytes of larger responses are kept, if maxSize is positive,
+// since the recent lines of a log matter the most. It returns whether the
+// response is truncated.
+func (m *SupportBundleManager) fetchKubelet(node, p, dest string, maxSize int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(m.context, kubeletRequestTimeout)
+	defer cancel()
+
+	stream, err := m.k8s.GetNodeProxyRequest(node, p).Stream(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer stream.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dest), os.FileMode(0755)); err != nil {
+		return false, err
+	}
+	f, err := utils.NewTailFile(dest, maxSize)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	if _, err := m.redactor.Copy(f, stream); err != nil {
+		return false, err
+	}
+	if err := f.Close(); err != nil {
+		return false, err
+	}
+	return f.Truncated(), nil
+}
@@ -0,0 +1,81 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/rest"
+
+	"github.com/rancher/support-bundle-kit/pkg/manager/client"
+	"github.com/rancher/support-bundle-kit/pkg/redact"
+)
+
+func TestFetchKubeletKeepsTail(t *testing.T) {
+	var log strings.Builder
+	for i := 1; i <= 10000; i++ {
+		fmt.Fprintf(&log, "line %d\n", i)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/v1/nodes/node-1/proxy/logs/syslog" {
+			http.NotFound(w, req)
+			return
+		}
+		_, _ = w.Write([]byte(log.String()))
+	}))
+	defer srv.Close()
+
+	m := newTestManager(t)
+	k8s, err := client.NewKubernetesClient(context.Background(), &rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.k8s = k8s
+	m.redactor = redact.NewRedactor(redact.SecretModeMask, nil)
+
+	tests := []struct {
+		name          string
+		maxSize       int64
+		want          string
+		wantTruncated bool
+	}{
+		{name: "no limit", want: log.String()},
+		{name: "under the limit", maxSize: int64(log.Len()), want: log.String()},
+		{name: "over the limit", maxSize: 21, want: "line 9999\nline 10000\n", wantTruncated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := filepath.Join(m.OutputDir, "kubelet", "syslog")
+			truncated, err := m.fetchKubelet("node-1", "/logs/syslog", dest, tt.maxSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadFile(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("log = %q..., want %q...", firstBytes(b), firstBytes([]byte(tt.want)))
+			}
+			if truncated != tt.wantTruncated {
+				t.Errorf("truncated = %v, want %v", truncated, tt.wantTruncated)
+			}
+		})
+	}
+
+	if _, err := m.fetchKubelet("node-1", "/logs/missing", filepath.Join(m.OutputDir, "missing"), 0); err == nil {
+		t.Errorf("fetching a missing log succeeded")
+	}
+}
+
+func firstBytes(b []byte) []byte {
+	if len(b) > 32 {
+		return b[:32]
+	}
+	return b
+}
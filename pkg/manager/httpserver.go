@@ -2,21 +2,30 @@ package manager
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
+	"github.com/rancher/support-bundle-kit/pkg/types"
 	"github.com/rancher/support-bundle-kit/pkg/utils"
 	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
+// maxNodeFailureSize is the max size of a node failure report
+const maxNodeFailureSize = 64 * 1024
+
 type HttpServer struct {
 	context context.Context
 	manager *SupportBundleManager
@@ -48,7 +57,7 @@ func (s *HttpServer) getBundle(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Type", s.manager.format.ContentType())
 	w.Header().Set("Content-Length", strconv.FormatInt(fstat.Size(), 10))
 	w.Header().Set("Content-Disposition", "attachment; filename="+filepath.Base(bundleFile))
 	if _, err := io.Copy(w, f); err != nil {
@@ -57,59 +66,166 @@ func (s *HttpServer) getBundle(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-func (s *HttpServer) createNodeBundle(w http.ResponseWriter, req *http.Request) {
-	node := mux.Vars(req)["nodeName"]
+// getNodeName returns the node name of a node request. The name is validated
+// against the Kubernetes node name rules, so it's safe to use in file paths.
+func (s *HttpServer) getNodeName(req *http.Request) (string, error) {
+	node, err := url.PathUnescape(mux.Vars(req)["nodeName"])
+	if err != nil {
+		return "", fmt.Errorf("invalid node name: %v", err)
+	}
 	if node == "" {
-		utils.HttpResponseError(w, http.StatusBadRequest, errors.New("empty node name"))
+		return "", errors.New("empty node name")
+	}
+	if errs := validation.IsDNS1123Subdomain(node); len(errs) > 0 {
+		return "", fmt.Errorf("invalid node name %q: %s", node, strings.Join(errs, ", "))
+	}
+	return node, nil
+}
+
+// authenticateAgent rejects node requests without the agent token of this
+// bundle, so only agents created by the manager can upload node bundles.
+func (s *HttpServer) authenticateAgent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token := s.manager.agentToken
+		auth := req.Header.Get("Authorization")
+		if token == "" || !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			utils.HttpResponseError(w, http.StatusUnauthorized, errors.New("invalid agent token"))
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func (s *HttpServer) createNodeBundle(w http.ResponseWriter, req *http.Request) {
+	node, err := s.getNodeName(req)
+	if err != nil {
+		utils.HttpResponseError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !s.manager.isNodePending(node) {
+		utils.HttpResponseError(w, http.StatusForbidden, fmt.Errorf("node %s is not expected", node))
+		return
+	}
+
+	maxSize := s.manager.maxNodeBundleSize
+	if req.ContentLength > maxSize {
+		utils.HttpResponseError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("node bundle of %s exceeds %d bytes", node, maxSize))
 		return
 	}
 
 	logrus.Debugf("handle create node bundle for %s", node)
 	nodesDir := filepath.Join(s.manager.getWorkingDir(), "nodes")
-	err := os.MkdirAll(nodesDir, os.FileMode(0775))
+	err = os.MkdirAll(nodesDir, os.FileMode(0775))
 	if err != nil {
 		utils.HttpResponseError(w, http.StatusInternalServerError, fmt.Errorf("fail to create directory %s: %v", nodesDir, err))
 		return
 	}
 
-	nodeBundle := filepath.Join(nodesDir, node+".zip")
-	f, err := os.Create(nodeBundle)
+	// write to a temporary file outside of the bundle first, so a partial
+	// upload never ends up in the bundle
+	f, err := ioutil.TempFile(s.manager.OutputDir, ".node-bundle-")
 	if err != nil {
-		utils.HttpResponseError(w, http.StatusInternalServerError, fmt.Errorf("fail to create file %s: %s", nodeBundle, err))
+		utils.HttpResponseError(w, http.StatusInternalServerError, fmt.Errorf("fail to create temporary file: %v", err))
 		return
 	}
-	defer f.Close()
-	_, err = io.Copy(f, req.Body)
+	tmpFile := f.Name()
+	defer os.Remove(tmpFile)
+
+	n, err := io.Copy(f, io.LimitReader(req.Body, maxSize+1))
+	f.Close()
 	if err != nil {
 		utils.HttpResponseError(w, http.StatusInternalServerError, err)
 		return
 	}
+	if n > maxSize {
+		utils.HttpResponseError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("node bundle of %s exceeds %d bytes", node, maxSize))
+		return
+	}
+
+	err = s.manager.verifyNodeBundle(tmpFile)
+	if err != nil {
+		utils.HttpResponseError(w, http.StatusBadRequest, fmt.Errorf("fail to verify node bundle of %s: %v", node, err))
+		return
+	}
 
-	err = s.manager.verifyNodeBundle(nodeBundle)
+	nodeBundle := filepath.Join(nodesDir, node+".zip")
+	err = os.Rename(tmpFile, nodeBundle)
 	if err != nil {
-		utils.HttpResponseError(w, http.StatusBadRequest, fmt.Errorf("fail to verify file %s: %v", nodeBundle, err))
+		utils.HttpResponseError(w, http.StatusInternalServerError, fmt.Errorf("fail to create file %s: %v", nodeBundle, err))
 		return
 	}
+	s.manager.status.SetNodeUploaded(node, n)
 	s.manager.completeNode(node)
 	utils.HttpResponseStatus(w, http.StatusCreated)
 }
 
-func (s *HttpServer) Run(m *SupportBundleManager) {
-	defaultTimeout := 30 * time.Second
+// createNodeFailure accepts the failure of an agent that can't produce a node
+// bundle, so the manager stops waiting for the node.
+func (s *HttpServer) createNodeFailure(w http.ResponseWriter, req *http.Request) {
+	node, err := s.getNodeName(req)
+	if err != nil {
+		utils.HttpResponseError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !s.manager.isNodePending(node) {
+		utils.HttpResponseError(w, http.StatusForbidden, fmt.Errorf("node %s is not expected", node))
+		return
+	}
+
+	failure := &types.NodeFailure{}
+	if err := json.NewDecoder(io.LimitReader(req.Body, maxNodeFailureSize)).Decode(failure); err != nil {
+		utils.HttpResponseError(w, http.StatusBadRequest, fmt.Errorf("fail to decode node failure of %s: %v", node, err))
+		return
+	}
+	if failure.Step == "" {
+		utils.HttpResponseError(w, http.StatusBadRequest, fmt.Errorf("failed step of %s is not specified", node))
+		return
+	}
+
+	logrus.Debugf("handle node failure for %s: %+v", node, failure)
+	if err := s.manager.failNode(node, failure); err != nil {
+		utils.HttpResponseError(w, http.StatusInternalServerError, err)
+		return
+	}
+	utils.HttpResponseStatus(w, http.StatusCreated)
+}
 
+// newRouter returns the handler of the API of the manager
+func (s *HttpServer) newRouter(m *SupportBundleManager) http.Handler {
 	r := mux.NewRouter()
 	r.UseEncodedPath()
 
-	r.Path("/status").Methods("GET").HandlerFunc(s.getStatus)
-	r.Path("/bundle").Methods("GET").HandlerFunc(s.getBundle)
-	r.Path("/nodes/{nodeName}").Methods("POST").HandlerFunc(s.createNodeBundle)
+	users := r.NewRoute().Subrouter()
+	if m.Auth {
+		users.Use(s.authorizeUser)
+	}
+	users.Path("/status").Methods("GET").HandlerFunc(s.getStatus)
+	users.Path("/bundle").Methods("GET").HandlerFunc(s.getBundle)
+
+	nodes := r.PathPrefix("/nodes").Subrouter()
+	nodes.Use(s.authenticateAgent)
+	nodes.Path("/{nodeName}").Methods("POST").HandlerFunc(s.createNodeBundle)
+	nodes.Path("/{nodeName}/failure").Methods("POST").HandlerFunc(s.createNodeFailure)
+	return r
+}
+
+func (s *HttpServer) Run(m *SupportBundleManager) {
+	defaultTimeout := 30 * time.Second
 
 	server := &http.Server{
 		Addr:           ":8080",
-		Handler:        r,
+		Handler:        s.newRouter(m),
 		ReadTimeout:    defaultTimeout,
 		WriteTimeout:   defaultTimeout,
 		MaxHeaderBytes: 1 << 20,
+		TLSConfig:      m.tlsConfig,
+	}
+	if m.tlsConfig != nil {
+		// the certificate is in TLSConfig
+		_ = server.ListenAndServeTLS("", "")
+		return
 	}
 	_ = server.ListenAndServe()
 }
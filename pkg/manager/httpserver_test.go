@@ -0,0 +1,237 @@
+package manager
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rancher/support-bundle-kit/pkg/types"
+)
+
+const testAgentToken = "agent-token"
+
+// newNodeBundle returns a zip archive of size bytes at least
+func newNodeBundle(t *testing.T, size int) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "node-1/logs/kernel.log", Method: zip.Store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(bytes.Repeat([]byte("x"), size)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// newTestServer returns the API of a manager waiting for node-1 and node-2
+func newTestServer(t *testing.T) (*SupportBundleManager, http.Handler) {
+	m := newTestManager(t)
+	m.agentToken = testAgentToken
+	m.maxNodeBundleSize = 4096
+	m.ch = make(chan struct{}, 1)
+	m.expectedNodes = map[string]string{"node-1": "", "node-2": ""}
+	m.status.InitNodes([]string{"node-1", "node-2"})
+	s := &HttpServer{context: m.context, manager: m}
+	return m, s.newRouter(m)
+}
+
+func postNode(handler http.Handler, path string, body []byte, contentLength int64) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, bytes.NewReader(body))
+	req.ContentLength = contentLength
+	req.Header.Set("Authorization", "Bearer "+testAgentToken)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// tempFiles lists the temporary upload files left in the output directory
+func tempFiles(t *testing.T, m *SupportBundleManager) []string {
+	files, err := filepath.Glob(filepath.Join(m.OutputDir, ".node-bundle-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestCreateNodeBundle(t *testing.T) {
+	valid := newNodeBundle(t, 100)
+	large := newNodeBundle(t, 8192)
+
+	tests := []struct {
+		name          string
+		path          string
+		body          []byte
+		contentLength int64
+		wantCode      int
+		wantFile      string
+	}{
+		{name: "upload", path: "/nodes/node-1", body: valid, contentLength: int64(len(valid)), wantCode: http.StatusCreated, wantFile: "node-1.zip"},
+		{name: "chunked upload", path: "/nodes/node-1", body: valid, contentLength: -1, wantCode: http.StatusCreated, wantFile: "node-1.zip"},
+		{name: "path traversal", path: "/nodes/..%2F..%2Fetc%2Fpasswd", body: valid, contentLength: int64(len(valid)), wantCode: http.StatusBadRequest},
+		{name: "upper case name", path: "/nodes/Node-1", body: valid, contentLength: int64(len(valid)), wantCode: http.StatusBadRequest},
+		{name: "unknown node", path: "/nodes/node-3", body: valid, contentLength: int64(len(valid)), wantCode: http.StatusForbidden},
+		{name: "content length over the limit", path: "/nodes/node-1", body: large, contentLength: int64(len(large)), wantCode: http.StatusRequestEntityTooLarge},
+		{name: "chunked body over the limit", path: "/nodes/node-1", body: large, contentLength: -1, wantCode: http.StatusRequestEntityTooLarge},
+		{name: "not a zip", path: "/nodes/node-1", body: []byte("garbage"), contentLength: 7, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, handler := newTestServer(t)
+			rec := postNode(handler, tt.path, tt.body, tt.contentLength)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body.String())
+			}
+
+			files, err := ioutil.ReadDir(filepath.Join(m.getWorkingDir(), "nodes"))
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+			if tt.wantFile == "" {
+				if len(files) != 0 {
+					t.Errorf("rejected upload is written to the bundle: %v", files)
+				}
+			} else {
+				b, err := ioutil.ReadFile(filepath.Join(m.getWorkingDir(), "nodes", tt.wantFile))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(b, tt.body) {
+					t.Errorf("node bundle differs from the upload")
+				}
+				if m.isNodePending("node-1") {
+					t.Errorf("node-1 is still pending after the upload")
+				}
+				if status := getNodeStatus(m, "node-1"); status.Phase != types.NodeBundlePhaseUploaded {
+					t.Errorf("status of node-1 = %s, want uploaded", status.Phase)
+				}
+			}
+			if files := tempFiles(t, m); len(files) != 0 {
+				t.Errorf("temporary files are left: %v", files)
+			}
+		})
+	}
+}
+
+func TestCreateNodeBundleTwice(t *testing.T) {
+	_, handler := newTestServer(t)
+	body := newNodeBundle(t, 100)
+	if rec := postNode(handler, "/nodes/node-1", body, int64(len(body))); rec.Code != http.StatusCreated {
+		t.Fatalf("first upload status = %d", rec.Code)
+	}
+	if rec := postNode(handler, "/nodes/node-1", body, int64(len(body))); rec.Code != http.StatusForbidden {
+		t.Errorf("second upload status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestCreateNodeFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		wantCode int
+	}{
+		{name: "failure", path: "/nodes/node-1/failure", body: `{"step":"command kernel.log","message":"exit status 1","stderr":"no journal"}`, wantCode: http.StatusCreated},
+		{name: "no step", path: "/nodes/node-1/failure", body: `{"message":"exit status 1"}`, wantCode: http.StatusBadRequest},
+		{name: "invalid json", path: "/nodes/node-1/failure", body: `{`, wantCode: http.StatusBadRequest},
+		{name: "unknown node", path: "/nodes/node-3/failure", body: `{"step":"collect"}`, wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, handler := newTestServer(t)
+			rec := postNode(handler, tt.path, []byte(tt.body), int64(len(tt.body)))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body.String())
+			}
+			if tt.wantCode != http.StatusCreated {
+				return
+			}
+			status := getNodeStatus(m, "node-1")
+			if status.Phase != types.NodeBundlePhaseFailed || !strings.Contains(status.FailureReason, "command kernel.log failed") {
+				t.Errorf("status of node-1 = %s %q, want failed", status.Phase, status.FailureReason)
+			}
+			if errLog := readErrorLog(t, m); !strings.Contains(errLog, "no journal") {
+				t.Errorf("stderr is not in the error log: %q", errLog)
+			}
+		})
+	}
+}
+
+func TestGetBundle(t *testing.T) {
+	m, handler := newTestServer(t)
+	m.bundleFileName = "supportbundle_test.zip"
+
+	req := httptest.NewRequest("GET", "/bundle", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status before the bundle is written = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	if err := ioutil.WriteFile(m.getBundlefile(), []byte("bundle"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("Content-Disposition"); got != "attachment; filename=supportbundle_test.zip" {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	if b, _ := ioutil.ReadAll(rec.Body); string(b) != "bundle" {
+		t.Errorf("body = %q, want the bundle", b)
+	}
+}
+
+func TestAuthenticateAgent(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    string
+		auth     string
+		wantCode int
+	}{
+		{name: "valid token", token: testAgentToken, auth: "Bearer " + testAgentToken, wantCode: http.StatusCreated},
+		{name: "no header", token: testAgentToken, auth: "", wantCode: http.StatusUnauthorized},
+		{name: "wrong token", token: testAgentToken, auth: "Bearer other-token", wantCode: http.StatusUnauthorized},
+		{name: "token prefix", token: testAgentToken, auth: "Bearer " + testAgentToken[:5], wantCode: http.StatusUnauthorized},
+		{name: "token with suffix", token: testAgentToken, auth: "Bearer " + testAgentToken + "x", wantCode: http.StatusUnauthorized},
+		{name: "basic auth", token: testAgentToken, auth: "Basic " + testAgentToken, wantCode: http.StatusUnauthorized},
+		{name: "lower case scheme", token: testAgentToken, auth: "bearer " + testAgentToken, wantCode: http.StatusUnauthorized},
+		// a manager without a token accepts no agent
+		{name: "no token generated", token: "", auth: "Bearer ", wantCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, handler := newTestServer(t)
+			m.agentToken = tt.token
+			for _, path := range []string{"/nodes/node-1/failure", "/nodes/node-2/failure"} {
+				body := `{"step":"collect"}`
+				req := httptest.NewRequest("POST", path, strings.NewReader(body))
+				if tt.auth != "" {
+					req.Header.Set("Authorization", tt.auth)
+				}
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				if rec.Code != tt.wantCode {
+					t.Errorf("POST %s status = %d, want %d", path, rec.Code, tt.wantCode)
+				}
+				if tt.wantCode == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != "Bearer" {
+					t.Errorf("POST %s doesn't ask for a bearer token", path)
+				}
+			}
+			if tt.wantCode == http.StatusUnauthorized && !m.isNodePending("node-1") {
+				t.Errorf("unauthenticated request completed node-1")
+			}
+		})
+	}
+}
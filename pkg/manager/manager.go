@@ -3,9 +3,10 @@ package manager
 import (
 	"archive/zip"
 	"context"
+	"crypto/tls"
 	"fmt"
+	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -14,28 +15,74 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rancher/wrangler/pkg/signals"
 	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
 
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 
+	"github.com/rancher/support-bundle-kit/pkg/archive"
 	"github.com/rancher/support-bundle-kit/pkg/manager/client"
+	"github.com/rancher/support-bundle-kit/pkg/redact"
 	"github.com/rancher/support-bundle-kit/pkg/types"
 	"github.com/rancher/support-bundle-kit/pkg/utils"
 )
 
 type SupportBundleManager struct {
-	Namespaces      []string
-	NamespaceList   string
-	BundleName      string
-	bundleFileName  string
-	OutputDir       string
-	WaitTimeout     time.Duration
-	ManagerPodIP    string
-	Standalone      bool
-	ImageName       string
-	ImagePullPolicy string
-	KubeConfig      string
-	PodNamespace    string
-	NodeSelector    string
+	Namespaces        []string
+	NamespaceList     string
+	NamespaceSelector string
+	AllNamespaces     bool
+	IncludeResources  string
+	ExcludeResources  string
+	FetchWorkers      int
+	EncodeWorkers     int
+	Profiles          string
+	BundleName        string
+	bundleFileName    string
+	OutputDir         string
+	OutputFormat      string
+	workingDir        string
+	format            archive.Format
+	createdAt         time.Time
+	WaitTimeout       time.Duration
+	ManagerPodIP      string
+	Standalone        bool
+	ImageName         string
+	ImagePullPolicy   string
+	KubeConfig        string
+	PodNamespace      string
+	NodeSelector      string
+	RedactSecrets     string
+	RedactionRules    string
+	Anonymize         bool
+	AnonymizeUsers    string
+	MaxNodeBundleSize string
+	maxNodeBundleSize int64
+	Auth              bool
+	AuthVerb          string
+	AuthResource      string
+	AuthGroup         string
+	TLS               bool
+	TLSCertFile       string
+	TLSKeyFile        string
+	AgentRestartLimit int32
+	SkipNotReadyNodes bool
+	NewNodes          string
+	KubeletFallback   bool
+	NodeNames         string
+
+	AgentTemplateFile      string
+	AgentTemplateConfigMap string
+	// AgentTemplate is used if neither a file nor a ConfigMap is set
+	AgentTemplate *AgentTemplate
+	UploadTargets []UploadTarget
+
+	// ClusterWideListThreshold is the number of namespaces from which
+	// resources are listed in all namespaces at once
+	ClusterWideListThreshold int
+	// PageSize is the number of objects fetched per list request
+	PageSize int64
 
 	context context.Context
 
@@ -43,43 +90,159 @@ type SupportBundleManager struct {
 	k8s        *client.KubernetesClient
 	k8sMetrics *client.MetricsClient
 	discovery  *client.DiscoveryClient
+	redactor   *redact.Redactor
+	anonymizer *redact.Anonymizer
 
 	state  StateStoreInterface
 	status ManagerStatus
 
+	agentToken string
+	tlsConfig  *tls.Config
+	caBundle   []byte
+
 	ch            chan struct{}
 	done          bool
 	nodesLock     sync.Mutex
 	expectedNodes map[string]string
+	// knownNodes are the nodes when the node phase started
+	knownNodes map[string]bool
+	// fallbackNodes are collected through the kubelet proxy
+	fallbackNodes []string
+	// selectedNodes are the explicitly selected nodes, all nodes matching
+	// the node selector are collected if it's empty
+	selectedNodes map[string]bool
+
+	agentTemplate  *AgentTemplate
+	profile        *Profile
+	resourceFilter *client.ResourceFilter
+	// namespaceRules are parsed from the namespace list and resolved into
+	// targetNamespaces before the cluster phase
+	namespaceRules   []namespaceRule
+	targetNamespaces []string
 }
 
 func (m *SupportBundleManager) check() error {
-	if len(m.Namespaces) == 0 || len(m.Namespaces[0]) == 0 {
+	if m.Profiles == "" {
+		m.Profiles = DefaultProfiles
+	}
+	profile, err := resolveProfiles(splitList(m.Profiles))
+	if err != nil {
+		return err
+	}
+	m.profile = profile
+	m.namespaceRules, err = parseNamespaceRules(m.Namespaces, m.NamespaceSelector)
+	if err != nil {
+		return err
+	}
+	if !m.hasNamespaceIncludes() {
 		return errors.New("namespace is not specified")
 	}
+	// like the discovery client, the defaults are used for workers that are
+	// not positive
+	if m.FetchWorkers <= 0 {
+		m.FetchWorkers = client.DefaultFetchWorkers
+	}
+	if m.EncodeWorkers <= 0 {
+		m.EncodeWorkers = client.DefaultEncodeWorkers
+	}
+	if m.PageSize < 0 {
+		return errors.New("page size must not be negative")
+	}
+	m.resourceFilter, err = client.NewResourceFilter(splitList(m.IncludeResources), splitList(m.ExcludeResources))
+	if err != nil {
+		return err
+	}
 	if m.BundleName == "" {
 		return errors.New("support bundle name is not specified")
 	}
-	if m.ManagerPodIP == "" {
-		return errors.New("manager pod IP is not specified")
+	if !m.Standalone {
+		if m.ManagerPodIP == "" {
+			return errors.New("manager pod IP is not specified")
+		}
+		if m.ImageName == "" {
+			return errors.New("image name is not specified")
+		}
+		if m.ImagePullPolicy == "" {
+			return errors.New("image pull policy is not specified")
+		}
+		if m.WaitTimeout <= 0 {
+			return errors.New("wait timeout must be positive")
+		}
+		maxSize, err := resource.ParseQuantity(m.MaxNodeBundleSize)
+		if err != nil {
+			return errors.Wrap(err, "invalid max node bundle size")
+		}
+		m.maxNodeBundleSize = maxSize.Value()
+		switch m.NewNodes {
+		case "":
+			m.NewNodes = NewNodesIgnore
+		case NewNodesIgnore, NewNodesAdd:
+		default:
+			return errors.Errorf("invalid new nodes policy %s, must be %s or %s", m.NewNodes, NewNodesIgnore, NewNodesAdd)
+		}
+		if (m.TLSCertFile == "") != (m.TLSKeyFile == "") {
+			return errors.New("TLS certificate and key must be specified together")
+		}
+		if m.TLSCertFile != "" {
+			m.TLS = true
+		}
+		if m.Auth && (m.AuthVerb == "" || m.AuthResource == "") {
+			return errors.New("auth verb and resource must be specified")
+		}
+	}
+	format, err := archive.ParseFormat(m.OutputFormat)
+	if err != nil {
+		return err
+	}
+	m.format = format
+	secretMode, err := redact.ParseSecretMode(m.RedactSecrets)
+	if err != nil {
+		return err
 	}
-	if m.ImageName == "" {
-		return errors.New("image name is not specified")
+	var rules *redact.Rules
+	if m.RedactionRules != "" {
+		if rules, err = redact.LoadRules(m.RedactionRules); err != nil {
+			return err
+		}
 	}
-	if m.ImagePullPolicy == "" {
-		return errors.New("image pull policy is not specified")
+	m.redactor = redact.NewRedactor(secretMode, rules)
+	if m.Anonymize {
+		m.anonymizer = redact.NewAnonymizer()
+		if m.AnonymizeUsers != "" {
+			m.anonymizer.AddUsernames(strings.Split(m.AnonymizeUsers, ",")...)
+		}
 	}
 	if m.OutputDir == "" {
 		m.OutputDir = filepath.Join(os.TempDir(), "support-bundle-kit")
 	}
-	if err := os.MkdirAll(m.getWorkingDir(), os.FileMode(0755)); err != nil {
+	return m.initWorkingDir()
+}
+
+// initWorkingDir prepares the directory the bundle is generated in. In
+// standalone mode the output directory is usually the user's current
+// directory, so a unique directory is used to avoid clobbering existing files.
+func (m *SupportBundleManager) initWorkingDir() error {
+	if err := os.MkdirAll(m.OutputDir, os.FileMode(0755)); err != nil {
 		return err
 	}
-	return nil
+	if m.Standalone {
+		dir, err := ioutil.TempDir(m.OutputDir, "bundle-")
+		if err != nil {
+			return err
+		}
+		m.workingDir = dir
+		return nil
+	}
+	m.workingDir = filepath.Join(m.OutputDir, "bundle")
+	return os.MkdirAll(m.workingDir, os.FileMode(0755))
 }
 
 func (m *SupportBundleManager) getWorkingDir() string {
-	return filepath.Join(m.OutputDir, "bundle")
+	return m.workingDir
+}
+
+func (m *SupportBundleManager) getErrorLogPath() string {
+	return filepath.Join(m.getWorkingDir(), "bundleGenerationError.log")
 }
 
 func (m *SupportBundleManager) getBundlefile() string {
@@ -94,7 +257,17 @@ func (m *SupportBundleManager) getBundlefilesize() (int64, error) {
 	return finfo.Size(), nil
 }
 
+// Run collects the bundle. An invalid configuration is returned before any
+// phase runs.
 func (m *SupportBundleManager) Run() error {
+	m.Namespaces = splitList(m.NamespaceList)
+	if err := m.check(); err != nil {
+		return errors.Wrap(err, "invalid configuration")
+	}
+	if m.context == nil {
+		m.context = signals.SetupSignalHandler(context.Background())
+	}
+
 	phases := []struct {
 		Name types.ManagerPhase
 		Run  func() error
@@ -127,6 +300,9 @@ func (m *SupportBundleManager) Run() error {
 		if err := phase.Run(); err != nil {
 			m.status.SetError(err.Error())
 			logrus.Errorf("failed to run phase %s: %s", phase.Name, err.Error())
+			if m.Standalone {
+				return errors.Wrapf(err, "fail to run phase %s", phase.Name)
+			}
 			break
 		}
 
@@ -135,18 +311,30 @@ func (m *SupportBundleManager) Run() error {
 		logrus.Infof("succeed to run phase %s. Progress (%d).", phase.Name, progress)
 	}
 
+	// In standalone mode nobody downloads the bundle from us, so there is no
+	// reason to keep running once it's written.
+	if m.Standalone {
+		return nil
+	}
+
 	<-m.context.Done()
 	return nil
 }
 
-func (m *SupportBundleManager) phaseInit() error {
-	m.Namespaces = strings.Split(m.NamespaceList, ",")
+// getTargetNamespaces returns the namespaces resolved from the selected
+// profiles and the namespace list of the user
+func (m *SupportBundleManager) getTargetNamespaces() []string {
+	return m.targetNamespaces
+}
 
-	if err := m.check(); err != nil {
-		return err
-	}
+// GetBundlefile returns the path of the generated bundle file.
+func (m *SupportBundleManager) GetBundlefile() string {
+	return m.getBundlefile()
+}
+
+func (m *SupportBundleManager) phaseInit() error {
+	m.createdAt = time.Now().UTC()
 
-	m.context = signals.SetupSignalHandler(context.Background())
 	err := m.initClients()
 	if err != nil {
 		return err
@@ -156,6 +344,10 @@ func (m *SupportBundleManager) phaseInit() error {
 
 	m.initStateStore()
 
+	if m.Standalone {
+		return nil
+	}
+
 	state, err := m.state.GetState(m.PodNamespace, m.BundleName)
 	if err != nil {
 		return err
@@ -164,6 +356,18 @@ func (m *SupportBundleManager) phaseInit() error {
 		return fmt.Errorf("invalid start state %s", state)
 	}
 
+	// agents authenticate their uploads with a token generated per bundle
+	m.agentToken, err = utils.RandomToken()
+	if err != nil {
+		return errors.Wrap(err, "fail to generate agent token")
+	}
+
+	if m.TLS {
+		if err := m.initTLS(); err != nil {
+			return err
+		}
+	}
+
 	// create a http server to
 	// (1) provide status to controller
 	// (2) accept node bundles from agent daemonset
@@ -178,6 +382,13 @@ func (m *SupportBundleManager) phaseInit() error {
 }
 
 func (m *SupportBundleManager) phaseCollectClusterBundle() error {
+	namespaces, err := m.resolveNamespaces()
+	if err != nil {
+		return err
+	}
+	m.targetNamespaces = namespaces
+	logrus.Infof("collecting namespaces %s", strings.Join(namespaces, ","))
+
 	cluster := NewCluster(m.context, m)
 	bundleName, err := cluster.GenerateClusterBundle(m.getWorkingDir())
 	if err != nil {
@@ -188,6 +399,11 @@ func (m *SupportBundleManager) phaseCollectClusterBundle() error {
 }
 
 func (m *SupportBundleManager) phaseCollectNodeBundles() error {
+	if m.Standalone {
+		logrus.Info("skip collecting node bundles in standalone mode")
+		return nil
+	}
+
 	err := m.collectNodeBundles()
 	if err != nil {
 		// Ignore error here, since in some failure cases we might not receive all node bundles.
@@ -198,7 +414,19 @@ func (m *SupportBundleManager) phaseCollectNodeBundles() error {
 }
 
 func (m *SupportBundleManager) phasePackaging() error {
-	return m.compressBundle()
+	if err := m.writeRedactionReport(); err != nil {
+		return err
+	}
+	if m.anonymizer != nil {
+		if err := m.anonymizeBundle(); err != nil {
+			return errors.Wrap(err, "fail to anonymize bundle")
+		}
+	}
+	if err := m.compressBundle(); err != nil {
+		return err
+	}
+	m.uploadBundle()
+	return nil
 }
 
 func (m *SupportBundleManager) phaseDone() error {
@@ -208,7 +436,11 @@ func (m *SupportBundleManager) phaseDone() error {
 
 func (m *SupportBundleManager) initClients() error {
 	var err error
-	m.restConfig, err = rest.InClusterConfig()
+	if m.KubeConfig != "" {
+		m.restConfig, err = clientcmd.BuildConfigFromFlags("", m.KubeConfig)
+	} else {
+		m.restConfig, err = rest.InClusterConfig()
+	}
 	if err != nil {
 		return err
 	}
@@ -227,6 +459,11 @@ func (m *SupportBundleManager) initClients() error {
 	if err != nil {
 		return err
 	}
+	m.discovery.Filter = m.resourceFilter
+	m.discovery.FetchWorkers = m.FetchWorkers
+	m.discovery.EncodeWorkers = m.EncodeWorkers
+	m.discovery.ClusterWideListThreshold = m.ClusterWideListThreshold
+	m.discovery.PageSize = m.PageSize
 	return nil
 }
 
@@ -235,25 +472,64 @@ func (m *SupportBundleManager) initStateStore() {
 }
 
 // collectNodeBundles spawns a daemonset on each node and waits for agents on
-// each node to push node bundles
+// each node to push node bundles. If not all nodes report back within
+// WaitTimeout, the missing nodes are recorded in the bundle and the manager
+// moves on with the bundles it has.
 func (m *SupportBundleManager) collectNodeBundles() error {
-	m.ch = make(chan struct{})
+	m.ch = make(chan struct{}, 1)
+
+	err := m.loadAgentTemplate()
+	if err != nil {
+		return err
+	}
 
-	err := m.refreshNodes()
+	err = m.refreshNodes()
 	if err != nil {
 		return err
 	}
 	logrus.Debugf("expected bundles from nodes: %+v", m.expectedNodes)
+	if len(m.expectedNodes) == 0 {
+		logrus.Warn("no nodes are ready to collect node bundles")
+		m.collectFallbackBundles()
+		return nil
+	}
 
 	// create a daemonset to collect node bundles and push back
 	agents := &AgentDaemonSet{sbm: m}
-	err = agents.Create(m.ImageName, fmt.Sprintf("http://%s:8080", m.ManagerPodIP))
+	err = agents.Create(m.ImageName, m.getManagerURL(), m.agentToken)
 	if err != nil {
 		return err
 	}
 
-	<-m.ch
-	logrus.Info("all node bundles are received.")
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	agents.Watch(stopCh, m.handleAgentPod)
+	m.watchNodes(stopCh)
+
+	var waitErr error
+	cancelled := false
+	select {
+	case <-m.ch:
+		logrus.Info("all node bundles are received.")
+	case <-time.After(m.WaitTimeout):
+		waitErr = m.handleNodesTimeout()
+	case <-m.context.Done():
+		cancelled = true
+	}
+
+	// agent logs are useful whether or not the node bundles are received,
+	// they must be collected before the daemonset is cleaned up
+	if err := m.collectAgentLogs(agents); err != nil {
+		logrus.Errorf("fail to collect agent logs: %v", err)
+	}
+	if cancelled {
+		return m.context.Err()
+	}
+
+	m.collectFallbackBundles()
+	if waitErr != nil {
+		return waitErr
+	}
 
 	// Clean up when everything is fine. If something went wrong, keep ds for debugging.
 	// The ds will be garbage-collected when manager pod is gone.
@@ -264,9 +540,59 @@ func (m *SupportBundleManager) collectNodeBundles() error {
 	return nil
 }
 
+// handleAgentPod records the state of an agent pod in the status of its node,
+// and fails the node if the pod will never upload a node bundle.
+func (m *SupportBundleManager) handleAgentPod(pod *corev1.Pod) {
+	node := getPodTargetNode(pod)
+	if node == "" {
+		return
+	}
+	m.status.SetNodeAgentPod(node, pod.Name, describePodState(pod))
+
+	reason := getPodFailure(pod, m.AgentRestartLimit)
+	if reason == "" || !m.isNodePending(node) {
+		return
+	}
+	logrus.Warnf("agent pod %s on node %s failed: %s", pod.Name, node, reason)
+	failure := &types.NodeFailure{
+		Step:    "agent pod " + pod.Name,
+		Message: reason,
+	}
+	if err := m.failNode(node, failure); err != nil {
+		logrus.Errorf("fail to fail node %s: %v", node, err)
+	}
+	m.requestFallback(node)
+}
+
+func (m *SupportBundleManager) collectAgentLogs(agents *AgentDaemonSet) error {
+	errLog, err := os.OpenFile(m.getErrorLogPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "fail to open bundle generation log")
+	}
+	defer errLog.Close()
+
+	agents.CollectLogs(filepath.Join(m.getWorkingDir(), "nodes", "agents"), errLog)
+	return nil
+}
+
 func (m *SupportBundleManager) verifyNodeBundle(file string) error {
-	_, err := zip.OpenReader(file)
-	return err
+	r, err := zip.OpenReader(file)
+	if err != nil {
+		return err
+	}
+	return r.Close()
+}
+
+// isNodePending tells if a bundle is expected from the node and not received yet
+func (m *SupportBundleManager) isNodePending(node string) bool {
+	m.nodesLock.Lock()
+	defer m.nodesLock.Unlock()
+
+	if m.done {
+		return false
+	}
+	_, ok := m.expectedNodes[node]
+	return ok
 }
 
 func (m *SupportBundleManager) completeNode(node string) {
@@ -290,20 +616,111 @@ func (m *SupportBundleManager) completeNode(node string) {
 	}
 }
 
+// failNode records the failure of a node in the generation error log and
+// completes the node, so the manager doesn't wait for its bundle.
+func (m *SupportBundleManager) failNode(node string, failure *types.NodeFailure) error {
+	reason := fmt.Sprintf("%s failed: %s", failure.Step, failure.Message)
+	if failure.ExitCode != nil {
+		reason = fmt.Sprintf("%s (exit code %d)", reason, *failure.ExitCode)
+	}
+
+	errLog, err := os.OpenFile(m.getErrorLogPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "fail to open bundle generation log")
+	}
+	defer errLog.Close()
+
+	fmt.Fprintf(errLog, "Support Bundle: node %s: %s\n", node, reason)
+	if stderr := strings.TrimSpace(failure.Stderr); stderr != "" {
+		fmt.Fprintf(errLog, "Support Bundle: node %s: stderr:\n%s\n", node, stderr)
+	}
+
+	m.status.SetNodeFailed(node, reason)
+	m.completeNode(node)
+	return nil
+}
+
+// handleNodesTimeout stops waiting for node bundles and writes the nodes that
+// never reported back, along with the state of their agent pods, into the bundle.
+func (m *SupportBundleManager) handleNodesTimeout() error {
+	m.nodesLock.Lock()
+	m.done = true
+	missing := make(map[string]string, len(m.expectedNodes))
+	for node := range m.expectedNodes {
+		missing[node] = ""
+	}
+	m.nodesLock.Unlock()
+
+	agents := &AgentDaemonSet{sbm: m}
+	states, err := agents.GetPodStates()
+	if err != nil {
+		logrus.Errorf("fail to get agent pod states: %v", err)
+	}
+	for node := range missing {
+		reason := "agent pod not found"
+		if state, ok := states[node]; ok {
+			reason = state.String()
+			m.status.SetNodeAgentPod(node, state.Pod, state.State)
+		}
+		missing[node] = reason
+		m.status.SetNodeFailed(node, "timed out: "+reason)
+		m.requestFallback(node)
+	}
+
+	errLog, err := os.OpenFile(m.getErrorLogPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "fail to open bundle generation log")
+	}
+	defer errLog.Close()
+
+	for node, reason := range missing {
+		fmt.Fprintf(errLog, "Support Bundle: timed out waiting for node %s: %s\n", node, reason)
+	}
+	encodeToYAMLFile(missing, filepath.Join(m.getWorkingDir(), "nodes", "missingNodes.yaml"), errLog)
+
+	return fmt.Errorf("timed out after %v waiting for node bundles from %d node(s)", m.WaitTimeout, len(missing))
+}
+
+// writeRedactionReport records how often each redaction rule was applied, so
+// reviewers can see what was masked.
+func (m *SupportBundleManager) writeRedactionReport() error {
+	errLog, err := os.OpenFile(m.getErrorLogPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "fail to open bundle generation log")
+	}
+	defer errLog.Close()
+
+	encodeToYAMLFile(m.redactor.Report(), filepath.Join(m.getWorkingDir(), "redaction-report.yaml"), errLog)
+	return nil
+}
+
+// compressBundle archives the working directory into the bundle file. Files
+// that can't be archived are skipped and reported in the generation error log,
+// which is archived last so it contains these errors too.
 func (m *SupportBundleManager) compressBundle() error {
-	bundleDir := strings.TrimSuffix(m.bundleFileName, filepath.Ext(m.getBundlefile()))
-	bundleDirPath := filepath.Join(m.OutputDir, bundleDir)
-	err := os.Rename(m.getWorkingDir(), bundleDirPath)
+	errLog, err := os.OpenFile(m.getErrorLogPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		return errors.Wrap(err, "fail to compress bundle")
+		return errors.Wrap(err, "fail to open bundle generation log")
 	}
-	cmd := exec.Command("zip", "-r", m.getBundlefile(), bundleDir)
-	cmd.Dir = m.OutputDir
-	err = cmd.Run()
+	defer errLog.Close()
+
+	opts := archive.Options{
+		Prefix:  strings.TrimSuffix(m.bundleFileName, m.format.Extension()),
+		ModTime: m.createdAt,
+		ErrLog:  errLog,
+		Last:    []string{filepath.Base(m.getErrorLogPath())},
+	}
+	err = archive.Create(m.getBundlefile(), m.getWorkingDir(), m.format, opts)
 	if err != nil {
 		return errors.Wrap(err, "fail to compress bundle")
 	}
 
+	if m.Standalone {
+		if err := os.RemoveAll(m.getWorkingDir()); err != nil {
+			logrus.Warnf("fail to remove %s: %v", m.getWorkingDir(), err)
+		}
+	}
+
 	size, err := m.getBundlefilesize()
 	if err != nil {
 		return errors.Wrap(err, "fail to get bundle file size")
@@ -322,10 +739,46 @@ func (m *SupportBundleManager) refreshNodes() error {
 		return errors.New("no nodes are found")
 	}
 
+	found := make(map[string]bool, len(nodes.Items))
+	for _, node := range nodes.Items {
+		found[node.Name] = true
+	}
+	for node := range m.selectedNodes {
+		if !found[node] {
+			logrus.Warnf("selected node %s is not found", node)
+		}
+	}
+
 	m.expectedNodes = make(map[string]string)
+	m.knownNodes = make(map[string]bool)
+	names := make([]string, 0, len(nodes.Items))
+	skipped := make(map[string]string)
+	unschedulable := make(map[string]string)
 	for _, node := range nodes.Items {
+		m.knownNodes[node.Name] = true
+		if !m.isNodeSelected(node.Name) {
+			continue
+		}
+		names = append(names, node.Name)
+		if reason := getNodeNotReadyReason(&node); reason != "" && m.SkipNotReadyNodes {
+			skipped[node.Name] = reason
+			continue
+		}
+		// don't wait the whole wait timeout for agents that never start
+		if reason := m.getAgentUnschedulableReason(&node); reason != "" {
+			unschedulable[node.Name] = reason
+			continue
+		}
 		m.expectedNodes[node.Name] = ""
 	}
+	m.status.InitNodes(names)
+	for node, reason := range skipped {
+		m.skipNode(node, reason)
+		m.requestFallback(node)
+	}
+	for node, reason := range unschedulable {
+		m.failUnschedulableNode(node, reason)
+	}
 
 	return nil
 }
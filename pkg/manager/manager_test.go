@@ -0,0 +1,248 @@
+package manager
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/rancher/support-bundle-kit/pkg/manager/client"
+	"github.com/rancher/support-bundle-kit/pkg/types"
+)
+
+// newTestManager returns a manager with a working directory and a fake
+// clientset holding objects
+func newTestManager(t *testing.T, objects ...runtime.Object) *SupportBundleManager {
+	return newTestManagerForClientSet(t, fake.NewSimpleClientset(objects...))
+}
+
+// newTestManagerForClientSet returns a manager with a working directory and
+// the clientset, e.g., a fake clientset the test inspects or adds reactors to
+func newTestManagerForClientSet(t *testing.T, clientSet kubernetes.Interface) *SupportBundleManager {
+	dir, err := ioutil.TempDir("", "manager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	m := &SupportBundleManager{
+		BundleName:   "sb-test",
+		PodNamespace: "cattle-system",
+		OutputDir:    dir,
+		context:      context.Background(),
+	}
+	m.k8s = client.NewKubernetesClientForClientSet(m.context, clientSet)
+	if err := m.initWorkingDir(); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// newAgentPod returns an agent pod of the test manager on a node
+func newAgentPod(name, node string, status corev1.PodStatus) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "cattle-system",
+			Labels: map[string]string{
+				"app":                       types.SupportBundleAgent,
+				types.SupportBundleLabelKey: "sb-test",
+			},
+		},
+		Spec:   corev1.PodSpec{NodeName: node},
+		Status: status,
+	}
+}
+
+func readErrorLog(t *testing.T, m *SupportBundleManager) string {
+	b, err := ioutil.ReadFile(m.getErrorLogPath())
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func getNodeStatus(m *SupportBundleManager, node string) types.NodeStatus {
+	m.status.RLock()
+	defer m.status.RUnlock()
+	for _, n := range m.status.Nodes {
+		if n.Name == node {
+			return n
+		}
+	}
+	return types.NodeStatus{}
+}
+
+func TestHandleNodesTimeout(t *testing.T) {
+	pod := newAgentPod("agent-1", "node-1", corev1.PodStatus{
+		Phase: corev1.PodPending,
+		ContainerStatuses: []corev1.ContainerStatus{{
+			State: corev1.ContainerState{
+				Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"},
+			},
+		}},
+	})
+	m := newTestManager(t, pod)
+	m.KubeletFallback = true
+	m.expectedNodes = map[string]string{"node-1": "", "node-2": "", "node-3": ""}
+	m.status.InitNodes([]string{"node-1", "node-2", "node-3"})
+	m.ch = make(chan struct{}, 1)
+	m.completeNode("node-3")
+
+	err := m.handleNodesTimeout()
+	if err == nil || !strings.Contains(err.Error(), "from 2 node(s)") {
+		t.Errorf("handleNodesTimeout() = %v, want a timeout of 2 nodes", err)
+	}
+	if m.isNodePending("node-1") {
+		t.Errorf("node-1 is still pending after the timeout")
+	}
+
+	wantReasons := map[string]string{
+		"node-1": "agent pod agent-1: Pending (ContainerCreating: )",
+		"node-2": "agent pod not found",
+	}
+	for node, reason := range wantReasons {
+		status := getNodeStatus(m, node)
+		if status.Phase != types.NodeBundlePhaseFailed || status.FailureReason != "timed out: "+reason {
+			t.Errorf("status of %s = %s %q, want failed %q", node, status.Phase, status.FailureReason, "timed out: "+reason)
+		}
+	}
+	if status := getNodeStatus(m, "node-1"); status.AgentPod != "agent-1" {
+		t.Errorf("agent pod of node-1 = %q, want agent-1", status.AgentPod)
+	}
+
+	// the missing nodes are recorded in the bundle
+	b, err := ioutil.ReadFile(filepath.Join(m.getWorkingDir(), "nodes", "missingNodes.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := map[string]string{}
+	if err := yaml.Unmarshal(b, &missing); err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 2 || missing["node-1"] != wantReasons["node-1"] || missing["node-2"] != wantReasons["node-2"] {
+		t.Errorf("missingNodes.yaml = %v, want %v", missing, wantReasons)
+	}
+	errLog := readErrorLog(t, m)
+	for node := range wantReasons {
+		if !strings.Contains(errLog, "timed out waiting for node "+node) {
+			t.Errorf("error log doesn't record %s: %q", node, errLog)
+		}
+	}
+
+	// the kubelet fallback collects the missing nodes
+	if len(m.fallbackNodes) != 2 {
+		t.Errorf("fallback nodes = %v, want node-1 and node-2", m.fallbackNodes)
+	}
+}
+
+func TestCompleteNode(t *testing.T) {
+	m := newTestManager(t)
+	m.ch = make(chan struct{}, 1)
+	m.expectedNodes = map[string]string{"node-1": "", "node-2": ""}
+
+	m.completeNode("node-1")
+	select {
+	case <-m.ch:
+		t.Fatalf("completed before all nodes reported")
+	default:
+	}
+	m.completeNode("node-1")
+	m.completeNode("node-2")
+	select {
+	case <-m.ch:
+	default:
+		t.Fatalf("not completed after all nodes reported")
+	}
+	if m.isNodePending("node-2") {
+		t.Errorf("node-2 is pending after completion")
+	}
+}
+
+func TestCheckWorkers(t *testing.T) {
+	tests := []struct {
+		name              string
+		fetch, encode     int
+		wantFetch, wantEn int
+	}{
+		{name: "set", fetch: 3, encode: 1, wantFetch: 3, wantEn: 1},
+		{name: "zero uses the defaults", wantFetch: client.DefaultFetchWorkers, wantEn: client.DefaultEncodeWorkers},
+		{name: "negative uses the defaults", fetch: -1, encode: -2, wantFetch: client.DefaultFetchWorkers, wantEn: client.DefaultEncodeWorkers},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "manager")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			m := &SupportBundleManager{
+				Standalone:    true,
+				BundleName:    "sb-test",
+				Namespaces:    []string{"default"},
+				OutputDir:     dir,
+				FetchWorkers:  tt.fetch,
+				EncodeWorkers: tt.encode,
+			}
+			if err := m.check(); err != nil {
+				t.Fatal(err)
+			}
+			if m.FetchWorkers != tt.wantFetch || m.EncodeWorkers != tt.wantEn {
+				t.Errorf("workers = %d, %d, want %d, %d", m.FetchWorkers, m.EncodeWorkers, tt.wantFetch, tt.wantEn)
+			}
+		})
+	}
+}
+
+func TestRunInvalidConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		manager *SupportBundleManager
+		want    string
+	}{
+		{
+			name:    "no manager pod IP",
+			manager: &SupportBundleManager{BundleName: "sb-test", OutputDir: dir},
+			want:    "manager pod IP is not specified",
+		},
+		{
+			name:    "no image",
+			manager: &SupportBundleManager{BundleName: "sb-test", NamespaceList: "default", ManagerPodIP: "10.0.0.1", OutputDir: dir},
+			want:    "image name is not specified",
+		},
+		{
+			name:    "invalid page size",
+			manager: &SupportBundleManager{Standalone: true, BundleName: "sb-test", NamespaceList: "default", PageSize: -1, OutputDir: dir},
+			want:    "page size must not be negative",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the manager isn't started, so Run neither waits for the
+			// context nor panics without one
+			err := tt.manager.Run()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Run() = %v, want %q", err, tt.want)
+			}
+			if phase := tt.manager.status.Phase; phase != "" {
+				t.Errorf("phase %s ran with an invalid configuration", phase)
+			}
+		})
+	}
+}
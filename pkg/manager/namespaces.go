@@ -0,0 +1,154 @@
+package manager
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// namespaceRule is an item of the namespace list. An item is a label selector
+// if it contains =, parentheses or spaces, e.g., env=prod or env!=dev,
+// otherwise it's a name or a glob pattern, e.g., tenant-*. Items prefixed
+// with ! exclude the namespaces they match. Since the list is delimited by ,
+// each item selects on a single label, selectors of several labels are set
+// with the namespace selector.
+type namespaceRule struct {
+	item     string
+	exclude  bool
+	pattern  string
+	selector labels.Selector
+}
+
+// parseNamespaceRules parses the namespace list and the namespace selector, a
+// label selector parsed as a whole, e.g., env=prod,tier=web selects the
+// namespaces with both labels.
+func parseNamespaceRules(items []string, selector string) ([]namespaceRule, error) {
+	rules := make([]namespaceRule, 0, len(items)+1)
+	for _, item := range items {
+		rule := namespaceRule{item: item}
+		s := item
+		if strings.HasPrefix(s, "!") && !strings.HasPrefix(s, "!=") {
+			rule.exclude = true
+			s = strings.TrimSpace(s[1:])
+		}
+		if s == "" {
+			return nil, fmt.Errorf("invalid namespace %q", item)
+		}
+
+		// namespace names can't contain the operators of selectors, so a
+		// set based selector split by , isn't taken as a name
+		if strings.ContainsAny(s, "=() ") {
+			selector, err := labels.Parse(s)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid namespace selector %q, selectors of several labels must be set with the namespace selector", item)
+			}
+			rule.selector = selector
+		} else {
+			if _, err := path.Match(s, ""); err != nil {
+				return nil, errors.Wrapf(err, "invalid namespace pattern %q", item)
+			}
+			rule.pattern = s
+		}
+		rules = append(rules, rule)
+	}
+
+	if selector != "" {
+		parsed, err := labels.Parse(selector)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid namespace selector %q", selector)
+		}
+		rules = append(rules, namespaceRule{item: selector, selector: parsed})
+	}
+	return rules, nil
+}
+
+// isLiteral returns whether the rule names a single namespace
+func (r *namespaceRule) isLiteral() bool {
+	return r.selector == nil && !strings.ContainsAny(r.pattern, `*?[\`)
+}
+
+func (r *namespaceRule) matches(ns *corev1.Namespace) bool {
+	if r.selector != nil {
+		return r.selector.Matches(labels.Set(ns.Labels))
+	}
+	matched, _ := path.Match(r.pattern, ns.Name)
+	return matched
+}
+
+// hasNamespaceIncludes returns whether any namespace is selected before the
+// list is resolved against the cluster
+func (m *SupportBundleManager) hasNamespaceIncludes() bool {
+	if m.AllNamespaces || len(m.profile.Namespaces) > 0 {
+		return true
+	}
+	for _, rule := range m.namespaceRules {
+		if !rule.exclude {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveNamespaces resolves the namespaces of the profiles and the namespace
+// list against the live namespaces of the cluster. Namespaces that are named
+// but don't exist are skipped.
+func (m *SupportBundleManager) resolveNamespaces() ([]string, error) {
+	list, err := m.k8s.GetAllNamespacesList()
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to list namespaces")
+	}
+
+	existing := make(map[string]bool, len(list.Items))
+	for _, ns := range list.Items {
+		existing[ns.Name] = true
+	}
+	for _, name := range m.profile.Namespaces {
+		if !existing[name] {
+			logrus.Debugf("namespace %s of the selected profiles is not found", name)
+		}
+	}
+	for _, rule := range m.namespaceRules {
+		if rule.isLiteral() && !rule.exclude && !existing[rule.pattern] {
+			logrus.Warnf("namespace %s is not found", rule.pattern)
+		}
+	}
+
+	var namespaces []string
+	for i := range list.Items {
+		ns := &list.Items[i]
+		if m.isNamespaceSelected(ns) {
+			namespaces = append(namespaces, ns.Name)
+		}
+	}
+	sort.Strings(namespaces)
+	if len(namespaces) == 0 {
+		return nil, errors.New("no namespace is selected")
+	}
+	return namespaces, nil
+}
+
+func (m *SupportBundleManager) isNamespaceSelected(ns *corev1.Namespace) bool {
+	selected := m.AllNamespaces
+	for _, name := range m.profile.Namespaces {
+		if name == ns.Name {
+			selected = true
+		}
+	}
+	for i := range m.namespaceRules {
+		rule := &m.namespaceRules[i]
+		if !rule.matches(ns) {
+			continue
+		}
+		if rule.exclude {
+			return false
+		}
+		selected = true
+	}
+	return selected
+}
@@ -0,0 +1,118 @@
+package manager
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newNamespace(name string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+	}
+}
+
+func TestIsNamespaceSelected(t *testing.T) {
+	namespaces := []*corev1.Namespace{
+		newNamespace("harvester-system", nil),
+		newNamespace("tenant-a", map[string]string{"env": "prod", "tier": "web"}),
+		newNamespace("tenant-b", map[string]string{"env": "prod", "tier": "db"}),
+		newNamespace("tenant-test", map[string]string{"env": "dev", "tier": "web"}),
+		newNamespace("kube-public", nil),
+	}
+
+	tests := []struct {
+		name     string
+		items    []string
+		selector string
+		all      bool
+		want     []string
+	}{
+		{
+			name:  "names and globs",
+			items: []string{"harvester-system", "tenant-*", "!tenant-test"},
+			want:  []string{"harvester-system", "tenant-a", "tenant-b"},
+		},
+		{
+			name:  "items are OR'ed",
+			items: []string{"env=prod", "tier=web"},
+			want:  []string{"tenant-a", "tenant-b", "tenant-test"},
+		},
+		{
+			name:     "selector terms are AND'ed",
+			selector: "env=prod,tier=web",
+			want:     []string{"tenant-a"},
+		},
+		{
+			name:     "set based selector",
+			selector: "tier in (web,db),env!=dev",
+			want:     []string{"tenant-a", "tenant-b"},
+		},
+		{
+			name:     "selector and exclusions",
+			items:    []string{"harvester-system", "!tenant-b"},
+			selector: "env=prod",
+			want:     []string{"harvester-system", "tenant-a"},
+		},
+		{
+			name:  "all namespaces",
+			items: []string{"!kube-public", "!env=dev"},
+			all:   true,
+			want:  []string{"harvester-system", "tenant-a", "tenant-b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := parseNamespaceRules(tt.items, tt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := &SupportBundleManager{
+				AllNamespaces:  tt.all,
+				profile:        &Profile{},
+				namespaceRules: rules,
+			}
+			var got []string
+			for _, ns := range namespaces {
+				if m.isNamespaceSelected(ns) {
+					got = append(got, ns.Name)
+				}
+			}
+			if !equalStrings(got, tt.want) {
+				t.Errorf("selected namespaces = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNamespaceRulesInvalid(t *testing.T) {
+	tests := []struct {
+		items    []string
+		selector string
+	}{
+		{items: []string{""}},
+		{items: []string{"!"}},
+		{items: []string{"tenant-["}},
+		// a set based selector split by ,
+		{items: []string{"tier in (web", "db)"}},
+		{selector: "=prod"},
+	}
+	for _, tt := range tests {
+		if _, err := parseNamespaceRules(tt.items, tt.selector); err == nil {
+			t.Errorf("parseNamespaceRules(%q, %q) succeeded, want an error", tt.items, tt.selector)
+		}
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
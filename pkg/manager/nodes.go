@@ -0,0 +1,161 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// watchNodes keeps the expected nodes in sync with the cluster until stopCh is
+// closed. Deleted nodes are no longer waited for, new nodes are handled by the
+// NewNodes policy.
+func (m *SupportBundleManager) watchNodes(stopCh <-chan struct{}) {
+	informer := m.k8s.NewNodeInformer(m.NodeSelector)
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if node, ok := obj.(*corev1.Node); ok {
+				m.handleNodeAdd(node)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if node, ok := obj.(*corev1.Node); ok {
+				m.handleNodeDelete(node)
+			}
+		},
+	})
+	go informer.Run(stopCh)
+}
+
+// handleNodeAdd is called for each node when the watch starts, only nodes
+// unknown by then joined during the collection.
+func (m *SupportBundleManager) handleNodeAdd(node *corev1.Node) {
+	if !m.isNodeSelected(node.Name) {
+		return
+	}
+	m.nodesLock.Lock()
+	if m.knownNodes[node.Name] {
+		m.nodesLock.Unlock()
+		return
+	}
+	m.knownNodes[node.Name] = true
+	add := m.NewNodes == NewNodesAdd && !m.done
+	unschedulable := ""
+	if add {
+		if unschedulable = m.getAgentUnschedulableReason(node); unschedulable == "" {
+			m.expectedNodes[node.Name] = ""
+		}
+	}
+	m.nodesLock.Unlock()
+
+	m.status.AddNode(node.Name)
+	if unschedulable != "" {
+		m.failUnschedulableNode(node.Name, unschedulable)
+		return
+	}
+	if add {
+		logrus.Infof("node %s joined, waiting for its node bundle", node.Name)
+		return
+	}
+	m.skipNode(node.Name, "node joined during the collection")
+}
+
+func (m *SupportBundleManager) handleNodeDelete(node *corev1.Node) {
+	if !m.isNodePending(node.Name) {
+		return
+	}
+	m.skipNode(node.Name, "node is deleted")
+	m.completeNode(node.Name)
+}
+
+// skipNode records why a node bundle is not collected
+func (m *SupportBundleManager) skipNode(node string, reason string) {
+	logrus.Infof("skip node %s: %s", node, reason)
+	m.status.SetNodeSkipped(node, reason)
+
+	errLog, err := os.OpenFile(m.getErrorLogPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		logrus.Errorf("fail to open bundle generation log: %v", err)
+		return
+	}
+	defer errLog.Close()
+	fmt.Fprintf(errLog, "Support Bundle: skipped node %s: %s\n", node, reason)
+}
+
+// daemonSetTolerations are added to the pods of every DaemonSet by the
+// DaemonSet controller
+var daemonSetTolerations = []corev1.Toleration{
+	{Key: corev1.TaintNodeNotReady, Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute},
+	{Key: corev1.TaintNodeUnreachable, Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute},
+	{Key: corev1.TaintNodeDiskPressure, Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule},
+	{Key: corev1.TaintNodeMemoryPressure, Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule},
+	{Key: corev1.TaintNodePIDPressure, Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule},
+	{Key: corev1.TaintNodeUnschedulable, Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule},
+}
+
+// getAgentUnschedulableReason returns why an agent pod is never created on a
+// node, or an empty string if it is. The DaemonSet controller doesn't create
+// pods on nodes with NoSchedule or NoExecute taints the pods don't tolerate,
+// so there is no pod whose status tells the node is stuck.
+func (m *SupportBundleManager) getAgentUnschedulableReason(node *corev1.Node) string {
+	template := m.agentTemplate
+	if template == nil {
+		template = &AgentTemplate{}
+	}
+	tolerations := append(template.getTolerations(), daemonSetTolerations...)
+
+	for i := range node.Spec.Taints {
+		taint := &node.Spec.Taints[i]
+		if taint.Effect == corev1.TaintEffectPreferNoSchedule {
+			continue
+		}
+		tolerated := false
+		for j := range tolerations {
+			if tolerations[j].ToleratesTaint(taint) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return fmt.Sprintf("agent pod can't be scheduled, taint %s is not tolerated", taint.ToString())
+		}
+	}
+	return ""
+}
+
+// failUnschedulableNode records a node the agent can't run on. The node is
+// not waited for, a partial node bundle is collected through the kubelet
+// proxy instead if it's enabled.
+func (m *SupportBundleManager) failUnschedulableNode(node string, reason string) {
+	logrus.Warnf("node %s failed: %s", node, reason)
+	m.status.SetNodeFailed(node, reason)
+	m.requestFallback(node)
+
+	errLog, err := os.OpenFile(m.getErrorLogPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		logrus.Errorf("fail to open bundle generation log: %v", err)
+		return
+	}
+	defer errLog.Close()
+	fmt.Fprintf(errLog, "Support Bundle: node %s: %s\n", node, reason)
+}
+
+// getNodeNotReadyReason returns why a node is not ready, or an empty string if
+// it's ready
+func getNodeNotReadyReason(node *corev1.Node) string {
+	for _, cond := range node.Status.Conditions {
+		if cond.Type != corev1.NodeReady {
+			continue
+		}
+		if cond.Status == corev1.ConditionTrue {
+			return ""
+		}
+		return fmt.Sprintf("node is not ready (%s: %s)", cond.Reason, cond.Message)
+	}
+	return "node is not ready (no Ready condition)"
+}
@@ -0,0 +1,104 @@
+package manager
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/rancher/support-bundle-kit/pkg/types"
+)
+
+func newNode(name string, taints ...corev1.Taint) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       corev1.NodeSpec{Taints: taints},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+func TestGetAgentUnschedulableReason(t *testing.T) {
+	dedicated := corev1.Taint{Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}
+
+	tests := []struct {
+		name     string
+		template *AgentTemplate
+		taints   []corev1.Taint
+		want     string
+	}{
+		{name: "no taints"},
+		{name: "untolerated NoSchedule", taints: []corev1.Taint{dedicated}, want: "taint dedicated=gpu:NoSchedule is not tolerated"},
+		{name: "untolerated NoExecute", taints: []corev1.Taint{{Key: "evict", Effect: corev1.TaintEffectNoExecute}}, want: "taint evict:NoExecute is not tolerated"},
+		{name: "PreferNoSchedule", taints: []corev1.Taint{{Key: "dedicated", Effect: corev1.TaintEffectPreferNoSchedule}}},
+		// tolerated by the DaemonSet controller
+		{name: "cordoned", taints: []corev1.Taint{{Key: corev1.TaintNodeUnschedulable, Effect: corev1.TaintEffectNoSchedule}}},
+		{name: "not ready", taints: []corev1.Taint{{Key: corev1.TaintNodeNotReady, Effect: corev1.TaintEffectNoExecute}}},
+		{name: "drained", taints: []corev1.Taint{{Key: types.DrainKey, Value: "scheduling", Effect: corev1.TaintEffectNoSchedule}}},
+		{
+			name:     "tolerated by the template",
+			template: &AgentTemplate{Tolerations: []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "gpu"}}},
+			taints:   []corev1.Taint{dedicated},
+		},
+		{name: "tolerate all", template: &AgentTemplate{TolerateAll: true}, taints: []corev1.Taint{dedicated}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &SupportBundleManager{agentTemplate: tt.template}
+			got := m.getAgentUnschedulableReason(newNode("node-1", tt.taints...))
+			if (got == "") != (tt.want == "") || !strings.Contains(got, tt.want) {
+				t.Errorf("getAgentUnschedulableReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefreshNodesFailsUnschedulableNodes(t *testing.T) {
+	tainted := newNode("node-2", corev1.Taint{Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule})
+	m := newTestManager(t, newNode("node-1"), tainted)
+	m.agentTemplate = &AgentTemplate{}
+	m.KubeletFallback = true
+
+	if err := m.refreshNodes(); err != nil {
+		t.Fatal(err)
+	}
+	if !m.isNodePending("node-1") {
+		t.Errorf("node-1 is not waited for")
+	}
+	if m.isNodePending("node-2") {
+		t.Errorf("node-2 is waited for, its agent is never scheduled")
+	}
+	status := getNodeStatus(m, "node-2")
+	if status.Phase != types.NodeBundlePhaseFailed || !strings.Contains(status.FailureReason, "dedicated=gpu:NoSchedule") {
+		t.Errorf("status of node-2 = %s %q, want failed by the taint", status.Phase, status.FailureReason)
+	}
+	if len(m.fallbackNodes) != 1 || m.fallbackNodes[0] != "node-2" {
+		t.Errorf("fallback nodes = %v, want node-2", m.fallbackNodes)
+	}
+	if errLog := readErrorLog(t, m); !strings.Contains(errLog, "node node-2: agent pod can't be scheduled") {
+		t.Errorf("error log doesn't record node-2: %q", errLog)
+	}
+}
+
+func TestHandleNodeAddFailsUnschedulableNodes(t *testing.T) {
+	m := newTestManager(t)
+	m.agentTemplate = &AgentTemplate{}
+	m.NewNodes = NewNodesAdd
+	m.expectedNodes = map[string]string{"node-1": ""}
+	m.knownNodes = map[string]bool{"node-1": true}
+
+	m.handleNodeAdd(newNode("node-2"))
+	m.handleNodeAdd(newNode("node-3", corev1.Taint{Key: "dedicated", Effect: corev1.TaintEffectNoExecute}))
+
+	if !m.isNodePending("node-2") {
+		t.Errorf("joined node-2 is not waited for")
+	}
+	if m.isNodePending("node-3") {
+		t.Errorf("joined node-3 is waited for, its agent is never scheduled")
+	}
+	if status := getNodeStatus(m, "node-3"); status.Phase != types.NodeBundlePhaseFailed {
+		t.Errorf("status of node-3 = %s, want failed", status.Phase)
+	}
+}
@@ -0,0 +1,146 @@
+package manager
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Profile is what to collect for a product. Profiles are combined by taking
+// the union of their settings.
+type Profile struct {
+	// Namespaces are collected in addition to the namespaces of the user
+	Namespaces []string
+	// APIGroups are expected on the cluster. Missing groups are recorded in
+	// the bundle metadata, they usually mean the product is not installed
+	// or broken.
+	APIGroups []string
+	// NodeCollectors are collector specs run by the agents in addition to the
+	// spec of the host OS
+	NodeCollectors []string
+	// ExternalBundles are collected from the product's own support bundle API
+	ExternalBundles []ExternalBundle
+	// Includes are other profiles this profile builds on
+	Includes []string
+}
+
+// DefaultProfiles are used if no profiles are selected
+const DefaultProfiles = "generic,rancher"
+
+var profiles = map[string]Profile{
+	"generic": {
+		Namespaces: []string{"default", "kube-system"},
+	},
+	"rancher": {
+		Namespaces: []string{"cattle-system", "cattle-fleet-system", "cattle-fleet-local-system", "fleet-local"},
+		APIGroups:  []string{"management.cattle.io", "fleet.cattle.io"},
+	},
+	"rke2": {
+		Namespaces:     []string{"kube-system"},
+		APIGroups:      []string{"helm.cattle.io"},
+		NodeCollectors: []string{"rke2"},
+	},
+	"longhorn": {
+		Namespaces:     []string{"longhorn-system"},
+		APIGroups:      []string{"longhorn.io"},
+		NodeCollectors: []string{"longhorn"},
+		ExternalBundles: []ExternalBundle{
+			{
+				Name: "longhorn",
+				Type: ExternalBundleTypeLonghorn,
+				URL:  "http://longhorn-backend.longhorn-system:9500",
+			},
+		},
+	},
+	"harvester": {
+		Namespaces: []string{"harvester-system", "harvester-public"},
+		APIGroups:  []string{"harvesterhci.io", "network.harvesterhci.io", "kubevirt.io", "cdi.kubevirt.io"},
+		Includes:   []string{"generic", "rancher", "rke2", "longhorn"},
+	},
+}
+
+// ProfileNames returns the names of the built-in profiles
+func ProfileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveProfiles combines the named profiles and the profiles they include
+func resolveProfiles(names []string) (*Profile, error) {
+	combined := &Profile{}
+	seen := make(map[string]bool)
+
+	var add func(name string) error
+	add = func(name string) error {
+		if seen[name] {
+			return nil
+		}
+		seen[name] = true
+		p, ok := profiles[name]
+		if !ok {
+			return fmt.Errorf("unknown profile %s, must be one of %s", name, strings.Join(ProfileNames(), ", "))
+		}
+		for _, include := range p.Includes {
+			if err := add(include); err != nil {
+				return err
+			}
+		}
+		combined.Namespaces = appendUnique(combined.Namespaces, p.Namespaces...)
+		combined.APIGroups = appendUnique(combined.APIGroups, p.APIGroups...)
+		combined.NodeCollectors = appendUnique(combined.NodeCollectors, p.NodeCollectors...)
+		for _, external := range p.ExternalBundles {
+			if !hasExternalBundle(combined.ExternalBundles, external.Name) {
+				combined.ExternalBundles = append(combined.ExternalBundles, external)
+			}
+		}
+		return nil
+	}
+
+	for _, name := range names {
+		if err := add(name); err != nil {
+			return nil, err
+		}
+	}
+	return combined, nil
+}
+
+func hasExternalBundle(bundles []ExternalBundle, name string) bool {
+	for _, b := range bundles {
+		if b.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// appendUnique appends the values that are not in list yet
+func appendUnique(list []string, values ...string) []string {
+	for _, v := range values {
+		found := false
+		for _, existing := range list {
+			if existing == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// splitList splits a list delimited by , and drops empty items
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,96 @@
+package manager
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveProfiles(t *testing.T) {
+	// profiles including each other, resolving them must not loop forever
+	saved := profiles
+	profiles = map[string]Profile{}
+	for name, p := range saved {
+		profiles[name] = p
+	}
+	profiles["cycle-a"] = Profile{Namespaces: []string{"ns-a"}, Includes: []string{"cycle-b"}}
+	profiles["cycle-b"] = Profile{Namespaces: []string{"ns-b"}, Includes: []string{"cycle-a"}}
+	profiles["self"] = Profile{Namespaces: []string{"ns-self"}, Includes: []string{"self"}}
+	profiles["broken"] = Profile{Includes: []string{"missing"}}
+	defer func() { profiles = saved }()
+
+	tests := []struct {
+		name               string
+		profiles           []string
+		wantNamespaces     []string
+		wantNodeCollectors []string
+		wantExternals      []string
+		wantErr            string
+	}{
+		{
+			name:           "single",
+			profiles:       []string{"generic"},
+			wantNamespaces: []string{"default", "kube-system"},
+		},
+		{
+			name:               "duplicated namespaces",
+			profiles:           []string{"generic", "rke2", "generic"},
+			wantNamespaces:     []string{"default", "kube-system"},
+			wantNodeCollectors: []string{"rke2"},
+		},
+		{
+			name:               "includes",
+			profiles:           []string{"harvester", "longhorn"},
+			wantNamespaces:     []string{"default", "kube-system", "cattle-system", "cattle-fleet-system", "cattle-fleet-local-system", "fleet-local", "longhorn-system", "harvester-system", "harvester-public"},
+			wantNodeCollectors: []string{"rke2", "longhorn"},
+			wantExternals:      []string{"longhorn"},
+		},
+		{
+			name:           "cycle",
+			profiles:       []string{"cycle-a"},
+			wantNamespaces: []string{"ns-b", "ns-a"},
+		},
+		{
+			name:           "includes itself",
+			profiles:       []string{"self"},
+			wantNamespaces: []string{"ns-self"},
+		},
+		{
+			name:     "unknown",
+			profiles: []string{"generic", "nope"},
+			wantErr:  "unknown profile nope",
+		},
+		{
+			name:     "unknown include",
+			profiles: []string{"broken"},
+			wantErr:  "unknown profile missing",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := resolveProfiles(tt.profiles)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("resolveProfiles() = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(p.Namespaces, tt.wantNamespaces) {
+				t.Errorf("namespaces = %v, want %v", p.Namespaces, tt.wantNamespaces)
+			}
+			if !reflect.DeepEqual(p.NodeCollectors, tt.wantNodeCollectors) {
+				t.Errorf("node collectors = %v, want %v", p.NodeCollectors, tt.wantNodeCollectors)
+			}
+			var externals []string
+			for _, e := range p.ExternalBundles {
+				externals = append(externals, e.Name)
+			}
+			if !reflect.DeepEqual(externals, tt.wantExternals) {
+				t.Errorf("external bundles = %v, want %v", externals, tt.wantExternals)
+			}
+		})
+	}
+}
@@ -1,8 +1,11 @@
 package manager
 
 import (
+	"sort"
 	"sync"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
 	"github.com/rancher/support-bundle-kit/pkg/types"
 )
 
@@ -36,3 +39,90 @@ func (s *ManagerStatus) SetFileinfo(filename string, filesize int64) {
 	s.FileName = filename
 	s.FileSize = filesize
 }
+
+// InitNodes resets the node statuses to pending for the given nodes
+func (s *ManagerStatus) InitNodes(nodes []string) {
+	s.Lock()
+	defer s.Unlock()
+	sort.Strings(nodes)
+	s.Nodes = make([]types.NodeStatus, 0, len(nodes))
+	for _, node := range nodes {
+		s.Nodes = append(s.Nodes, types.NodeStatus{
+			Name:  node,
+			Phase: types.NodeBundlePhasePending,
+		})
+	}
+}
+
+// AddNode adds a pending node, e.g., a node joining during the collection
+func (s *ManagerStatus) AddNode(node string) {
+	s.Lock()
+	defer s.Unlock()
+	s.Nodes = append(s.Nodes, types.NodeStatus{
+		Name:  node,
+		Phase: types.NodeBundlePhasePending,
+	})
+	sort.Slice(s.Nodes, func(i, j int) bool {
+		return s.Nodes[i].Name < s.Nodes[j].Name
+	})
+}
+
+// updateNodeLocked calls update on the status of a node, if the node is known
+func (s *ManagerStatus) updateNodeLocked(node string, update func(*types.NodeStatus)) {
+	for i := range s.Nodes {
+		if s.Nodes[i].Name == node {
+			update(&s.Nodes[i])
+			return
+		}
+	}
+}
+
+func (s *ManagerStatus) SetNodeAgentPod(node, pod, state string) {
+	s.Lock()
+	defer s.Unlock()
+	s.updateNodeLocked(node, func(n *types.NodeStatus) {
+		n.AgentPod = pod
+		n.AgentPodState = state
+	})
+}
+
+func (s *ManagerStatus) SetNodeUploaded(node string, filesize int64) {
+	s.Lock()
+	defer s.Unlock()
+	s.updateNodeLocked(node, func(n *types.NodeStatus) {
+		n.Phase = types.NodeBundlePhaseUploaded
+		n.UploadedAt = metav1.Now()
+		n.FileSize = filesize
+		n.FailureReason = ""
+	})
+}
+
+func (s *ManagerStatus) SetNodeFailed(node string, reason string) {
+	s.Lock()
+	defer s.Unlock()
+	s.updateNodeLocked(node, func(n *types.NodeStatus) {
+		n.Phase = types.NodeBundlePhaseFailed
+		n.FailureReason = reason
+	})
+}
+
+func (s *ManagerStatus) SetNodeSkipped(node string, reason string) {
+	s.Lock()
+	defer s.Unlock()
+	s.updateNodeLocked(node, func(n *types.NodeStatus) {
+		n.Phase = types.NodeBundlePhaseSkipped
+		n.FailureReason = reason
+	})
+}
+
+// SetNodePartial records a partial node bundle collected without the agent.
+// The failure reason of the agent is kept.
+func (s *ManagerStatus) SetNodePartial(node string, filesize int64) {
+	s.Lock()
+	defer s.Unlock()
+	s.updateNodeLocked(node, func(n *types.NodeStatus) {
+		n.Partial = true
+		n.UploadedAt = metav1.Now()
+		n.FileSize = filesize
+	})
+}
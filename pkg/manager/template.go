@@ -0,0 +1,144 @@
+package manager
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/rancher/support-bundle-kit/pkg/types"
+)
+
+// agentTemplateKey is the key of the agent template in its ConfigMap
+const agentTemplateKey = "agent-template.yaml"
+
+// AgentTemplate customizes the agent pods, e.g.,
+//
+//	tolerateAll: true
+//	priorityClassName: system-node-critical
+//	resources:
+//	  requests:
+//	    cpu: 100m
+//	    memory: 128Mi
+//	imagePullSecrets:
+//	- name: registry
+//	serviceAccountName: support-bundle-agent
+//	nodeNames: [node1, node2]
+type AgentTemplate struct {
+	// TolerateAll runs the agent on nodes with any taints
+	TolerateAll bool `json:"tolerateAll,omitempty"`
+	// Tolerations are added to the default toleration of kubevirt.io/drain
+	Tolerations        []corev1.Toleration           `json:"tolerations,omitempty"`
+	PriorityClassName  string                        `json:"priorityClassName,omitempty"`
+	Resources          corev1.ResourceRequirements   `json:"resources,omitempty"`
+	ImagePullSecrets   []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	ServiceAccountName string                        `json:"serviceAccountName,omitempty"`
+	// NodeNames limits the collection to the listed nodes, in addition to
+	// the node selector
+	NodeNames []string `json:"nodeNames,omitempty"`
+}
+
+func parseAgentTemplate(data []byte) (*AgentTemplate, error) {
+	template := &AgentTemplate{}
+	if err := yaml.UnmarshalStrict(data, template); err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+// loadAgentTemplate reads the agent template from AgentTemplateFile or
+// AgentTemplateConfigMap in the manager's namespace. AgentTemplate, or an
+// empty template, is used if neither is set.
+func (m *SupportBundleManager) loadAgentTemplate() error {
+	var data []byte
+	var source string
+	switch {
+	case m.AgentTemplateFile != "":
+		source = m.AgentTemplateFile
+		content, err := ioutil.ReadFile(m.AgentTemplateFile)
+		if err != nil {
+			return errors.Wrap(err, "fail to read agent template")
+		}
+		data = content
+	case m.AgentTemplateConfigMap != "":
+		source = fmt.Sprintf("configmap %s/%s", m.PodNamespace, m.AgentTemplateConfigMap)
+		cm, err := m.k8s.GetConfigMap(m.PodNamespace, m.AgentTemplateConfigMap)
+		if err != nil {
+			return errors.Wrap(err, "fail to get agent template configmap")
+		}
+		content, ok := cm.Data[agentTemplateKey]
+		if !ok {
+			return fmt.Errorf("%s is not found in %s", agentTemplateKey, source)
+		}
+		data = []byte(content)
+	}
+
+	template := m.AgentTemplate
+	if template == nil || data != nil {
+		var err error
+		if template, err = parseAgentTemplate(data); err != nil {
+			return fmt.Errorf("invalid agent template %s: %v", source, err)
+		}
+	}
+	m.agentTemplate = template
+	m.selectedNodes = make(map[string]bool)
+	for _, node := range m.getNodeNames() {
+		m.selectedNodes[node] = true
+	}
+	return nil
+}
+
+// getNodeNames returns the explicitly selected nodes of the flag and the agent
+// template, nil if all nodes matching the node selector are collected.
+func (m *SupportBundleManager) getNodeNames() []string {
+	names := splitList(m.NodeNames)
+	if m.agentTemplate != nil {
+		names = append(names, m.agentTemplate.NodeNames...)
+	}
+	return names
+}
+
+// isNodeSelected tells if a node matching the node selector is collected
+func (m *SupportBundleManager) isNodeSelected(node string) bool {
+	return len(m.selectedNodes) == 0 || m.selectedNodes[node]
+}
+
+func (t *AgentTemplate) getTolerations() []corev1.Toleration {
+	if t.TolerateAll {
+		return []corev1.Toleration{{Operator: corev1.TolerationOpExists}}
+	}
+	tolerations := []corev1.Toleration{
+		{
+			Key:   types.DrainKey,
+			Value: "scheduling",
+		},
+	}
+	return append(tolerations, t.Tolerations...)
+}
+
+// getAgentAffinity pins the agent pods to the explicitly selected nodes, if any
+func (m *SupportBundleManager) getAgentAffinity() *corev1.Affinity {
+	names := m.getNodeNames()
+	if len(names) == 0 {
+		return nil
+	}
+	return &corev1.Affinity{
+		NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{
+					{
+						MatchFields: []corev1.NodeSelectorRequirement{
+							{
+								Key:      "metadata.name",
+								Operator: corev1.NodeSelectorOpIn,
+								Values:   names,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
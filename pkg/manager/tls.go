@@ -0,0 +1,62 @@
+package manager
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rancher/support-bundle-kit/pkg/types"
+	"github.com/rancher/support-bundle-kit/pkg/utils"
+)
+
+// initTLS loads the provided certificate and key, or generates a self-signed
+// CA and a server certificate for the manager pod IP. The CA bundle is passed
+// to agents, so they can verify the manager.
+func (m *SupportBundleManager) initTLS() error {
+	var cert, key []byte
+	var err error
+
+	if m.TLSCertFile != "" || m.TLSKeyFile != "" {
+		if cert, err = ioutil.ReadFile(m.TLSCertFile); err != nil {
+			return errors.Wrap(err, "fail to read TLS certificate")
+		}
+		if key, err = ioutil.ReadFile(m.TLSKeyFile); err != nil {
+			return errors.Wrap(err, "fail to read TLS key")
+		}
+		// the certificate is trusted as is, it's either self-signed or
+		// contains the chain agents should trust
+		m.caBundle = cert
+	} else {
+		ip := net.ParseIP(m.ManagerPodIP)
+		if ip == nil {
+			return errors.Errorf("invalid manager pod IP %s", m.ManagerPodIP)
+		}
+		logrus.Info("generating self-signed certificate for the manager")
+		m.caBundle, cert, key, err = utils.GenerateSelfSignedCerts(types.SupportBundleManager, []net.IP{ip}, nil)
+		if err != nil {
+			return errors.Wrap(err, "fail to generate self-signed certificate")
+		}
+	}
+
+	pair, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		return errors.Wrap(err, "fail to load TLS key pair")
+	}
+	m.tlsConfig = &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		MinVersion:   tls.VersionTLS12,
+	}
+	return nil
+}
+
+// getManagerURL returns the URL agents upload node bundles to
+func (m *SupportBundleManager) getManagerURL() string {
+	scheme := "http"
+	if m.tlsConfig != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(m.ManagerPodIP, "8080")
+}
@@ -0,0 +1,150 @@
+package manager
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rancher/support-bundle-kit/pkg/utils"
+)
+
+// getStatusOverTLS gets /status of the manager served with its TLS config,
+// trusting only roots
+func getStatusOverTLS(t *testing.T, m *SupportBundleManager, roots []byte) error {
+	s := &HttpServer{context: m.context, manager: m}
+	server := httptest.NewUnstartedServer(s.newRouter(m))
+	server.TLS = m.tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(roots) {
+		t.Fatalf("invalid CA bundle")
+	}
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	resp, err := c.Get(server.URL + "/status")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	return nil
+}
+
+func TestInitTLSSelfSigned(t *testing.T) {
+	m := newTestManager(t)
+	m.ManagerPodIP = "127.0.0.1"
+	if err := m.initTLS(); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.getManagerURL(); got != "https://127.0.0.1:8080" {
+		t.Errorf("getManagerURL() = %s, want https", got)
+	}
+	if m.tlsConfig.MinVersion != tls.VersionTLS12 {
+		t.Errorf("min TLS version = %x, want TLS 1.2", m.tlsConfig.MinVersion)
+	}
+
+	// agents trust the CA bundle, which verifies the pod IP
+	if err := getStatusOverTLS(t, m, m.caBundle); err != nil {
+		t.Errorf("agent can't verify the manager: %v", err)
+	}
+
+	// another manager's CA isn't trusted
+	other := newTestManager(t)
+	other.ManagerPodIP = "127.0.0.1"
+	if err := other.initTLS(); err != nil {
+		t.Fatal(err)
+	}
+	if err := getStatusOverTLS(t, m, other.caBundle); err == nil {
+		t.Errorf("manager is verified with another CA")
+	}
+}
+
+func TestInitTLSProvidedCertificate(t *testing.T) {
+	ca, cert, key, err := utils.GenerateSelfSignedCerts("manager.example.com", []net.IP{net.ParseIP("127.0.0.1")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newTestManager(t)
+	m.ManagerPodIP = "127.0.0.1"
+	m.TLSCertFile = filepath.Join(m.OutputDir, "tls.crt")
+	m.TLSKeyFile = filepath.Join(m.OutputDir, "tls.key")
+	// the chain agents should trust is in the certificate file
+	chain := append(append([]byte{}, cert...), ca...)
+	if err := ioutil.WriteFile(m.TLSCertFile, chain, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(m.TLSKeyFile, key, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.initTLS(); err != nil {
+		t.Fatal(err)
+	}
+	if string(m.caBundle) != string(chain) {
+		t.Errorf("CA bundle isn't the provided certificate file")
+	}
+	if err := getStatusOverTLS(t, m, ca); err != nil {
+		t.Errorf("manager can't be verified with the provided CA: %v", err)
+	}
+}
+
+func TestInitTLSErrors(t *testing.T) {
+	_, cert, _, err := utils.GenerateSelfSignedCerts("a", []net.IP{net.ParseIP("127.0.0.1")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, otherKey, err := utils.GenerateSelfSignedCerts("b", []net.IP{net.ParseIP("127.0.0.1")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		podIP   string
+		cert    []byte
+		key     []byte
+		wantErr string
+	}{
+		{name: "invalid pod IP", podIP: "not-an-ip", wantErr: "invalid manager pod IP"},
+		{name: "mismatched key", podIP: "127.0.0.1", cert: cert, key: otherKey, wantErr: "fail to load TLS key pair"},
+		{name: "missing key file", podIP: "127.0.0.1", cert: cert, wantErr: "fail to read TLS key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t)
+			m.ManagerPodIP = tt.podIP
+			if tt.cert != nil {
+				m.TLSCertFile = filepath.Join(m.OutputDir, "tls.crt")
+				m.TLSKeyFile = filepath.Join(m.OutputDir, "tls.key")
+				if err := ioutil.WriteFile(m.TLSCertFile, tt.cert, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.key != nil {
+				if err := ioutil.WriteFile(m.TLSKeyFile, tt.key, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := m.initTLS()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("initTLS() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetManagerURLWithoutTLS(t *testing.T) {
+	m := &SupportBundleManager{ManagerPodIP: "fd00::1"}
+	if got := m.getManagerURL(); got != "http://[fd00::1]:8080" {
+		t.Errorf("getManagerURL() = %s", got)
+	}
+}
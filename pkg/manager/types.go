@@ -10,16 +10,23 @@ const (
 	PhaseDone          = "done"
 
 	BundleVersion = "0.1.0"
+
+	// policies of nodes joining during the node phase
+	NewNodesIgnore = "ignore"
+	NewNodesAdd    = "add"
 )
 
 type BundleMeta struct {
-	BundleName           string `json:"projectName"`
-	BundleVersion        string `json:"bundleVersion"`
-	KubernetesVersion    string `json:"kubernetesVersion"`
-	ProjectNamespaceUUID string `json:"projectNamspaceUUID"`
-	BundleCreatedAt      string `json:"bundleCreatedAt"`
-	IssueURL             string `json:"issueURL"`
-	IssueDescription     string `json:"issueDescription"`
+	BundleName           string   `json:"projectName"`
+	BundleVersion        string   `json:"bundleVersion"`
+	KubernetesVersion    string   `json:"kubernetesVersion"`
+	ProjectNamespaceUUID string   `json:"projectNamspaceUUID"`
+	BundleCreatedAt      string   `json:"bundleCreatedAt"`
+	IssueURL             string   `json:"issueURL"`
+	IssueDescription     string   `json:"issueDescription"`
+	Profiles             []string `json:"profiles"`
+	Namespaces           []string `json:"namespaces"`
+	MissingAPIGroups     []string `json:"missingAPIGroups,omitempty"`
 }
 
 type StateStoreInterface interface {
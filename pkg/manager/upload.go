@@ -0,0 +1,71 @@
+package manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const defaultUploadTimeout = 30 * time.Minute
+
+// uploadBundle puts the bundle file to each upload target. Failures are
+// logged, the bundle is still available for download.
+func (m *SupportBundleManager) uploadBundle() {
+	for _, target := range m.UploadTargets {
+		url := target.URL
+		if strings.HasSuffix(url, "/") {
+			url += m.bundleFileName
+		}
+		logrus.Infof("uploading bundle to %s", url)
+		if err := m.uploadBundleTo(url, target); err != nil {
+			logrus.Errorf("fail to upload bundle to %s: %v", url, err)
+			continue
+		}
+		logrus.Infof("bundle is uploaded to %s", url)
+	}
+}
+
+func (m *SupportBundleManager) uploadBundleTo(url string, target UploadTarget) error {
+	f, err := os.Open(m.getBundlefile())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fstat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(m.context, http.MethodPut, url, f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = fstat.Size()
+	req.Header.Set("Content-Type", m.format.ContentType())
+	for key, value := range target.Headers {
+		req.Header.Set(key, value)
+	}
+
+	timeout := target.Timeout
+	if timeout == 0 {
+		timeout = defaultUploadTimeout
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected response %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
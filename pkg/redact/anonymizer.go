@@ -0,0 +1,305 @@
+package redact
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	tokenHostname = "node"
+	tokenIP       = "ip"
+	tokenUsername = "user"
+)
+
+var (
+	ipv4Regexp = regexp.MustCompile(`\b(?:[0-9]{1,3}\.){3}[0-9]{1,3}\b`)
+	// ipv6Regexp matches candidates of IPv6 addresses, including the IPv4
+	// mapped form. Candidates are verified with net.ParseIP, so times and MAC
+	// addresses are kept.
+	ipv6Regexp = regexp.MustCompile(`(?i)(?:[0-9a-f]{0,4}:){2,6}(?:[0-9]{1,3}\.){3}[0-9]{1,3}|[0-9a-f]{0,4}(?::[0-9a-f]{0,4}){2,7}`)
+)
+
+// Anonymizer replaces hostnames, IP addresses and usernames with stable
+// tokens, e.g., node-1 and ip-7. The same value is always replaced with the
+// same token, so the bundle stays consistent across files. A token never
+// equals a known value, e.g., a node named node-1. Names must be added before
+// strings are anonymized, since adding a name that equals an issued token
+// moves that token. It's safe for concurrent use.
+type Anonymizer struct {
+	lock sync.Mutex
+
+	// tokens maps a value to its token, per kind of value
+	tokens map[string]map[string]string
+	// owners maps an issued token to its value
+	owners map[string]string
+	// counters is the number of the last token, per kind of value
+	counters map[string]int
+	// names is a regexp matching all known hostnames and usernames
+	names *regexp.Regexp
+}
+
+// Mapping is the table of replaced values. It must stay with the customer and
+// never go into the bundle.
+type Mapping struct {
+	Hostnames map[string]string `yaml:"hostnames"`
+	IPs       map[string]string `yaml:"ips"`
+	Usernames map[string]string `yaml:"usernames"`
+}
+
+func NewAnonymizer() *Anonymizer {
+	return &Anonymizer{
+		tokens: map[string]map[string]string{
+			tokenHostname: {},
+			tokenIP:       {},
+			tokenUsername: {},
+		},
+		owners:   map[string]string{},
+		counters: map[string]int{},
+	}
+}
+
+// HostnameCount returns the number of known hostnames
+func (a *Anonymizer) HostnameCount() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return len(a.tokens[tokenHostname])
+}
+
+func (a *Anonymizer) AddHostnames(names ...string) {
+	a.addNames(tokenHostname, names)
+}
+
+func (a *Anonymizer) AddUsernames(names ...string) {
+	a.addNames(tokenUsername, names)
+}
+
+func (a *Anonymizer) addNames(kind string, names []string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	added := false
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if _, ok := a.tokens[kind][name]; ok {
+			continue
+		}
+		// the name is taken by a token, move the token to a new one
+		if owner, ok := a.owners[name]; ok {
+			a.reissueLocked(owner, name)
+		}
+		a.tokenLocked(kind, name)
+		added = true
+	}
+	if added {
+		a.compileNamesLocked()
+	}
+}
+
+func (a *Anonymizer) tokenLocked(kind, value string) string {
+	if token, ok := a.tokens[kind][value]; ok {
+		return token
+	}
+	token := a.newTokenLocked(kind)
+	if token == value {
+		token = a.newTokenLocked(kind)
+	}
+	a.tokens[kind][value] = token
+	a.owners[token] = value
+	return token
+}
+
+// newTokenLocked returns the next token of a kind that isn't a known value
+func (a *Anonymizer) newTokenLocked(kind string) string {
+	for {
+		a.counters[kind]++
+		token := fmt.Sprintf("%s-%d", kind, a.counters[kind])
+		if !a.isKnownLocked(token) {
+			return token
+		}
+	}
+}
+
+func (a *Anonymizer) isKnownLocked(value string) bool {
+	for _, tokens := range a.tokens {
+		if _, ok := tokens[value]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// reissueLocked gives the value holding token a new token
+func (a *Anonymizer) reissueLocked(value, token string) {
+	delete(a.owners, token)
+	for kind, tokens := range a.tokens {
+		if tokens[value] != token {
+			continue
+		}
+		newToken := a.newTokenLocked(kind)
+		tokens[value] = newToken
+		a.owners[newToken] = value
+	}
+}
+
+// compileNamesLocked builds a regexp matching any known name as a whole word.
+// Longer names come first so they win over names they contain.
+func (a *Anonymizer) compileNamesLocked() {
+	var names []string
+	for _, kind := range []string{tokenHostname, tokenUsername} {
+		for name := range a.tokens[kind] {
+			names = append(names, regexp.QuoteMeta(name))
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i]) != len(names[j]) {
+			return len(names[i]) > len(names[j])
+		}
+		return names[i] < names[j]
+	})
+	a.names = regexp.MustCompile(`\b(?:` + strings.Join(names, "|") + `)\b`)
+}
+
+// Learn registers hostnames of Nodes and usernames of Users found in a list
+// of discovered objects.
+func (a *Anonymizer) Learn(list interface{}) {
+	l, ok := list.(map[string]interface{})
+	if !ok {
+		return
+	}
+	items, ok := l["items"].([]interface{})
+	if !ok {
+		return
+	}
+
+	var hostnames, usernames []string
+	for _, item := range items {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch obj["kind"] {
+		case "Node":
+			if metadata, ok := obj["metadata"].(map[string]interface{}); ok {
+				name, _ := metadata["name"].(string)
+				hostnames = append(hostnames, name)
+			}
+			status, _ := obj["status"].(map[string]interface{})
+			addresses, _ := status["addresses"].([]interface{})
+			for _, address := range addresses {
+				addr, _ := address.(map[string]interface{})
+				if addr["type"] == "Hostname" {
+					name, _ := addr["address"].(string)
+					hostnames = append(hostnames, name)
+				}
+			}
+		case "User":
+			if username, ok := obj["username"].(string); ok {
+				usernames = append(usernames, username)
+			}
+		}
+	}
+	a.AddHostnames(hostnames...)
+	a.AddUsernames(usernames...)
+}
+
+// AnonymizeString replaces all known names and IPv4 and IPv6 addresses in s.
+// Loopback, unspecified and link-local addresses are kept since they identify
+// nothing.
+func (a *Anonymizer) AnonymizeString(s string) string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.names != nil {
+		s = a.names.ReplaceAllStringFunc(s, func(name string) string {
+			if token, ok := a.tokens[tokenHostname][name]; ok {
+				return token
+			}
+			return a.tokens[tokenUsername][name]
+		})
+	}
+	s = replaceIPs(s, ipv6Regexp, a.ipTokenLocked)
+	return replaceIPs(s, ipv4Regexp, a.ipTokenLocked)
+}
+
+func (a *Anonymizer) ipTokenLocked(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() {
+		return addr
+	}
+	// the same address is replaced with the same token in any form
+	return a.tokenLocked(tokenIP, ip.String())
+}
+
+// replaceIPs replaces the matches of re that aren't part of a longer word,
+// e.g., a hex string or a version number
+func replaceIPs(s string, re *regexp.Regexp, replace func(string) string) string {
+	matches := re.FindAllStringIndex(s, -1)
+	if len(matches) == 0 {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if continuesBefore(s, start) || continuesAfter(s, end) {
+			continue
+		}
+		b.WriteString(s[last:start])
+		b.WriteString(replace(s[start:end]))
+		last = end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// continuesBefore tells if the word at i starts before i. A dot only joins
+// words, e.g., 1.2.3.4.5, so the end of a sentence isn't part of a word.
+func continuesBefore(s string, i int) bool {
+	if i == 0 {
+		return false
+	}
+	c := s[i-1]
+	if c == '.' {
+		return i >= 2 && isWordChar(s[i-2])
+	}
+	return isWordChar(c)
+}
+
+func continuesAfter(s string, i int) bool {
+	if i >= len(s) {
+		return false
+	}
+	c := s[i]
+	if c == '.' {
+		return i+1 < len(s) && isWordChar(s[i+1])
+	}
+	return isWordChar(c)
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func (a *Anonymizer) Mapping() *Mapping {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	copyTokens := func(kind string) map[string]string {
+		m := make(map[string]string, len(a.tokens[kind]))
+		for k, v := range a.tokens[kind] {
+			m[k] = v
+		}
+		return m
+	}
+	return &Mapping{
+		Hostnames: copyTokens(tokenHostname),
+		IPs:       copyTokens(tokenIP),
+		Usernames: copyTokens(tokenUsername),
+	}
+}
@@ -0,0 +1,115 @@
+package redact
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnonymizeIPs(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "IPv4", in: "node at 10.0.0.1", want: "node at ip-1"},
+		{name: "IPv4 with port", in: "dial 10.0.0.1:6443", want: "dial ip-1:6443"},
+		{name: "IPv4 end of sentence", in: "address is 10.0.0.1.", want: "address is ip-1."},
+		{name: "IPv6", in: "pod at 2001:db8::1", want: "pod at ip-1"},
+		{name: "IPv6 full", in: "[2001:0db8:0000:0000:0000:ff00:0042:8329]:80", want: "[ip-1]:80"},
+		{name: "IPv6 same address in other form", in: "2001:db8::1 2001:DB8:0:0:0:0:0:1", want: "ip-1 ip-1"},
+		{name: "IPv4 mapped IPv6", in: "::ffff:10.0.0.1 10.0.0.1", want: "ip-1 ip-1"},
+		{name: "loopback", in: "127.0.0.1 ::1", want: "127.0.0.1 ::1"},
+		{name: "unspecified", in: "0.0.0.0 ::", want: "0.0.0.0 ::"},
+		{name: "link local", in: "fe80::1", want: "fe80::1"},
+		{name: "time", in: "12:34:56.789", want: "12:34:56.789"},
+		{name: "MAC address", in: "52:54:00:12:34:56", want: "52:54:00:12:34:56"},
+		{name: "version", in: "v1.2.3.4.5", want: "v1.2.3.4.5"},
+		{name: "C++ scope", in: "std::vector", want: "std::vector"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAnonymizer()
+			if got := a.AnonymizeString(tt.in); got != tt.want {
+				t.Errorf("AnonymizeString(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnonymizeNames(t *testing.T) {
+	a := NewAnonymizer()
+	a.AddHostnames("worker-a", "worker-a.example.com")
+	a.AddUsernames("alice")
+
+	got := a.AnonymizeString("alice logged in to worker-a.example.com and worker-a")
+	want := "user-1 logged in to node-2 and node-1"
+	if got != want {
+		t.Errorf("AnonymizeString() = %q, want %q", got, want)
+	}
+	if n := a.HostnameCount(); n != 2 {
+		t.Errorf("HostnameCount() = %d, want 2", n)
+	}
+}
+
+func TestAnonymizeTokensDontCollide(t *testing.T) {
+	// a real node named like the first token
+	a := NewAnonymizer()
+	a.AddHostnames("node-1", "worker-a")
+	mapping := a.Mapping()
+	if mapping.Hostnames["node-1"] == "node-1" || mapping.Hostnames["worker-a"] == "node-1" {
+		t.Errorf("tokens collide with a hostname: %v", mapping.Hostnames)
+	}
+
+	// the name is learned after its token was issued
+	a = NewAnonymizer()
+	a.AddHostnames("worker-a")
+	a.AddHostnames("node-1")
+	mapping = a.Mapping()
+	if mapping.Hostnames["worker-a"] == "node-1" {
+		t.Errorf("token of worker-a collides with a hostname: %v", mapping.Hostnames)
+	}
+	if mapping.Hostnames["node-1"] == mapping.Hostnames["worker-a"] {
+		t.Errorf("hostnames share a token: %v", mapping.Hostnames)
+	}
+
+	got := a.AnonymizeString("worker-a node-1")
+	if strings.Contains(got, "worker-a") || got == "node-1 node-1" {
+		t.Errorf("AnonymizeString() = %q, want distinct tokens", got)
+	}
+	tokens := strings.Fields(got)
+	if tokens[0] != mapping.Hostnames["worker-a"] || tokens[1] != mapping.Hostnames["node-1"] {
+		t.Errorf("AnonymizeString() = %q, doesn't match mapping %v", got, mapping.Hostnames)
+	}
+}
+
+func TestAnonymizerLearn(t *testing.T) {
+	a := NewAnonymizer()
+	a.Learn(map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{
+				"kind":     "Node",
+				"metadata": map[string]interface{}{"name": "worker-a"},
+				"status": map[string]interface{}{
+					"addresses": []interface{}{
+						map[string]interface{}{"type": "Hostname", "address": "worker-a.local"},
+						map[string]interface{}{"type": "InternalIP", "address": "10.0.0.1"},
+					},
+				},
+			},
+			map[string]interface{}{
+				"kind":     "User",
+				"username": "alice",
+			},
+		},
+	})
+
+	mapping := a.Mapping()
+	for _, name := range []string{"worker-a", "worker-a.local"} {
+		if _, ok := mapping.Hostnames[name]; !ok {
+			t.Errorf("hostname %s is not learned: %v", name, mapping.Hostnames)
+		}
+	}
+	if _, ok := mapping.Usernames["alice"]; !ok {
+		t.Errorf("username alice is not learned: %v", mapping.Usernames)
+	}
+}
@@ -0,0 +1,116 @@
+package redact
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// segment is a step of a field path. It either selects a key of a map, all
+// keys of a map, an element of a list, or all elements of a list.
+type segment struct {
+	key      string
+	anyKey   bool
+	anyIndex bool
+	hasIndex bool
+	index    int
+}
+
+// parsePath parses a JSONPath-style field selector, e.g.,
+//
+//	spec.containers[*].env[*].value
+//	spec.containers[0].args
+//	data.*
+//	metadata.annotations["kubectl.kubernetes.io/last-applied-configuration"]
+func parsePath(path string) ([]segment, error) {
+	var segments []segment
+	s := strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	for len(s) > 0 {
+		switch {
+		case strings.HasPrefix(s, "[*]"):
+			segments = append(segments, segment{anyIndex: true})
+			s = s[3:]
+		case strings.HasPrefix(s, "[\"") || strings.HasPrefix(s, "['"):
+			quote := s[1:2]
+			end := strings.Index(s[2:], quote+"]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %q: unterminated key", path)
+			}
+			segments = append(segments, segment{key: s[2 : 2+end]})
+			s = s[2+end+2:]
+		case strings.HasPrefix(s, "["):
+			end := strings.Index(s, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %q: unterminated bracket", path)
+			}
+			index, err := strconv.Atoi(s[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid path %q: unsupported bracket %s, must be [*], a list index or a quoted key", path, s[:end+1])
+			}
+			segments = append(segments, segment{hasIndex: true, index: index})
+			s = s[end+1:]
+		case strings.HasPrefix(s, "."):
+			s = s[1:]
+		default:
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			key := s[:end]
+			if key == "*" {
+				segments = append(segments, segment{anyKey: true})
+			} else {
+				segments = append(segments, segment{key: key})
+			}
+			s = s[end:]
+		}
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("invalid path %q: empty path", path)
+	}
+	return segments, nil
+}
+
+// replaceAt calls replace on each value selected by the path and stores the
+// result in place. It returns the number of replaced values.
+func replaceAt(obj interface{}, segments []segment, replace func(interface{}) interface{}) int {
+	if len(segments) == 0 {
+		return 0
+	}
+	seg, rest := segments[0], segments[1:]
+
+	count := 0
+	switch v := obj.(type) {
+	case map[string]interface{}:
+		if seg.anyIndex || seg.hasIndex {
+			return 0
+		}
+		for key, value := range v {
+			if !seg.anyKey && key != seg.key {
+				continue
+			}
+			if len(rest) == 0 {
+				v[key] = replace(value)
+				count++
+				continue
+			}
+			count += replaceAt(value, rest, replace)
+		}
+	case []interface{}:
+		if !seg.anyIndex && !seg.hasIndex {
+			return 0
+		}
+		for i, value := range v {
+			if seg.hasIndex && i != seg.index {
+				continue
+			}
+			if len(rest) == 0 {
+				v[i] = replace(value)
+				count++
+				continue
+			}
+			count += replaceAt(value, rest, replace)
+		}
+	}
+	return count
+}
@@ -0,0 +1,142 @@
+package redact
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want []segment
+	}{
+		{
+			path: "data.*",
+			want: []segment{{key: "data"}, {anyKey: true}},
+		},
+		{
+			path: "$.spec.containers[*].env[*].value",
+			want: []segment{{key: "spec"}, {key: "containers"}, {anyIndex: true}, {key: "env"}, {anyIndex: true}, {key: "value"}},
+		},
+		{
+			path: `metadata.annotations["kubectl.kubernetes.io/last-applied-configuration"]`,
+			want: []segment{{key: "metadata"}, {key: "annotations"}, {key: "kubectl.kubernetes.io/last-applied-configuration"}},
+		},
+		{
+			path: `metadata.annotations['a.b'].c`,
+			want: []segment{{key: "metadata"}, {key: "annotations"}, {key: "a.b"}, {key: "c"}},
+		},
+		{
+			path: "spec.containers[0].name",
+			want: []segment{{key: "spec"}, {key: "containers"}, {hasIndex: true, index: 0}, {key: "name"}},
+		},
+		{
+			path: "items[12][*]",
+			want: []segment{{key: "items"}, {hasIndex: true, index: 12}, {anyIndex: true}},
+		},
+	}
+	for _, tt := range tests {
+		got, err := parsePath(tt.path)
+		if err != nil {
+			t.Errorf("parsePath(%q) error = %v", tt.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePath(%q) = %+v, want %+v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParsePathInvalid(t *testing.T) {
+	tests := []struct {
+		path    string
+		wantErr string
+	}{
+		{path: "", wantErr: "empty path"},
+		{path: "$.", wantErr: "empty path"},
+		{path: "x[foo]", wantErr: "unsupported bracket [foo]"},
+		{path: "x[-1]", wantErr: "unsupported bracket [-1]"},
+		{path: "x[]", wantErr: "unsupported bracket []"},
+		{path: "x[0", wantErr: "unterminated bracket"},
+		{path: `x["a.b`, wantErr: "unterminated key"},
+		{path: `x['a.b"]`, wantErr: "unterminated key"},
+	}
+	for _, tt := range tests {
+		_, err := parsePath(tt.path)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("parsePath(%q) error = %v, want %q", tt.path, err, tt.wantErr)
+		}
+	}
+}
+
+func TestReplaceAt(t *testing.T) {
+	newObj := func() map[string]interface{} {
+		return map[string]interface{}{
+			"spec": map[string]interface{}{
+				"containers": []interface{}{
+					map[string]interface{}{"name": "a", "env": []interface{}{"x", "y"}},
+					map[string]interface{}{"name": "b", "env": []interface{}{"z"}},
+				},
+			},
+			"metadata": map[string]interface{}{
+				"annotations": map[string]interface{}{"a.b": "1", "c": "2"},
+			},
+		}
+	}
+	replace := func(interface{}) interface{} { return "R" }
+
+	tests := []struct {
+		path      string
+		wantCount int
+		check     func(obj map[string]interface{}) bool
+	}{
+		{
+			path:      "spec.containers[0].name",
+			wantCount: 1,
+			check: func(obj map[string]interface{}) bool {
+				containers := obj["spec"].(map[string]interface{})["containers"].([]interface{})
+				return containers[0].(map[string]interface{})["name"] == "R" &&
+					containers[1].(map[string]interface{})["name"] == "b"
+			},
+		},
+		{
+			path:      "spec.containers[*].env[*]",
+			wantCount: 3,
+		},
+		{
+			path:      "spec.containers[5].name",
+			wantCount: 0,
+		},
+		{
+			path:      `metadata.annotations["a.b"]`,
+			wantCount: 1,
+			check: func(obj map[string]interface{}) bool {
+				annotations := obj["metadata"].(map[string]interface{})["annotations"].(map[string]interface{})
+				return annotations["a.b"] == "R" && annotations["c"] == "2"
+			},
+		},
+		{
+			path:      "metadata.annotations.*",
+			wantCount: 2,
+		},
+		{
+			// an index doesn't select keys of a map
+			path:      "metadata[0]",
+			wantCount: 0,
+		},
+	}
+	for _, tt := range tests {
+		segments, err := parsePath(tt.path)
+		if err != nil {
+			t.Fatalf("parsePath(%q) error = %v", tt.path, err)
+		}
+		obj := newObj()
+		if got := replaceAt(obj, segments, replace); got != tt.wantCount {
+			t.Errorf("replaceAt(%q) = %d, want %d", tt.path, got, tt.wantCount)
+		}
+		if tt.check != nil && !tt.check(obj) {
+			t.Errorf("replaceAt(%q) replaced the wrong values: %v", tt.path, obj)
+		}
+	}
+}
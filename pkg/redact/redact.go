@@ -0,0 +1,247 @@
+package redact
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// SecretMode controls how values of Secrets are written to the bundle
+type SecretMode string
+
+const (
+	// SecretModeHash replaces values with a hash and length placeholder, so
+	// values can still be compared between objects without being revealed.
+	SecretModeHash = SecretMode("hash")
+	// SecretModeMask replaces values with a fixed placeholder.
+	SecretModeMask = SecretMode("mask")
+	// SecretModeNone keeps Secrets as they are.
+	SecretModeNone = SecretMode("none")
+
+	maskPlaceholder = "REDACTED"
+
+	secretRuleName = "secrets"
+
+	lastAppliedConfigAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+)
+
+func ParseSecretMode(s string) (SecretMode, error) {
+	switch m := SecretMode(s); m {
+	case SecretModeHash, SecretModeMask, SecretModeNone:
+		return m, nil
+	case "":
+		return SecretModeHash, nil
+	}
+	return "", fmt.Errorf("unsupported secret redaction mode %q", s)
+}
+
+// Redactor masks sensitive data of discovered objects and logs before they are
+// written to the bundle. Objects are the decoded JSON of a list, as returned by
+// the discovery client. It's safe for concurrent use.
+type Redactor struct {
+	Secrets SecretMode
+	Rules   *Rules
+
+	countsLock sync.Mutex
+	counts     map[string]int
+}
+
+// NewRedactor creates a redactor, rules can be nil if there are no custom
+// rules.
+func NewRedactor(secrets SecretMode, rules *Rules) *Redactor {
+	if rules == nil {
+		rules = &Rules{}
+	}
+	return &Redactor{
+		Secrets: secrets,
+		Rules:   rules,
+		counts:  make(map[string]int),
+	}
+}
+
+func (r *Redactor) count(rule string, n int) {
+	if n == 0 {
+		return
+	}
+	r.countsLock.Lock()
+	defer r.countsLock.Unlock()
+	r.counts[rule] += n
+}
+
+// Report returns how often each rule has been applied so far
+func (r *Redactor) Report() *Report {
+	r.countsLock.Lock()
+	defer r.countsLock.Unlock()
+
+	report := &Report{
+		Rules: []RuleReport{
+			{Name: secretRuleName, Type: ruleTypeSecret, Count: r.counts[secretRuleName]},
+		},
+	}
+	for _, rule := range r.Rules.Fields {
+		report.Rules = append(report.Rules, RuleReport{Name: rule.Name, Type: ruleTypeField, Count: r.counts[rule.Name]})
+	}
+	for _, rule := range r.Rules.Patterns {
+		report.Rules = append(report.Rules, RuleReport{Name: rule.Name, Type: ruleTypePattern, Count: r.counts[rule.Name]})
+	}
+	return report
+}
+
+// Redact masks sensitive data of each item in a list in place.
+func (r *Redactor) Redact(list interface{}) {
+	l, ok := list.(map[string]interface{})
+	if !ok {
+		return
+	}
+	items, ok := l["items"].([]interface{})
+	if !ok {
+		return
+	}
+	for _, item := range items {
+		if obj, ok := item.(map[string]interface{}); ok {
+			r.RedactObject(obj)
+		}
+	}
+}
+
+// RedactObject masks sensitive data of a single object in place.
+func (r *Redactor) RedactObject(obj map[string]interface{}) {
+	if isSecret(obj) {
+		r.redactSecret(obj)
+	}
+
+	for i := range r.Rules.Fields {
+		rule := &r.Rules.Fields[i]
+		if !rule.matchKind(obj) {
+			continue
+		}
+		for _, path := range rule.paths {
+			n := replaceAt(obj, path, func(interface{}) interface{} {
+				return rule.Replacement
+			})
+			r.count(rule.Name, n)
+		}
+	}
+
+	if len(r.Rules.Patterns) > 0 {
+		r.redactStrings(obj)
+	}
+}
+
+// redactStrings applies pattern rules to all string values in place.
+func (r *Redactor) redactStrings(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if s, ok := value.(string); ok {
+				v[key] = r.RedactString(s)
+				continue
+			}
+			r.redactStrings(value)
+		}
+	case []interface{}:
+		for i, value := range v {
+			if s, ok := value.(string); ok {
+				v[i] = r.RedactString(s)
+				continue
+			}
+			r.redactStrings(value)
+		}
+	}
+}
+
+// RedactString applies pattern rules to a string
+func (r *Redactor) RedactString(s string) string {
+	for i := range r.Rules.Patterns {
+		rule := &r.Rules.Patterns[i]
+		matches := rule.re.FindAllStringIndex(s, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		r.count(rule.Name, len(matches))
+		s = rule.re.ReplaceAllString(s, rule.Replacement)
+	}
+	return s
+}
+
+// Copy copies src to dst line by line and applies pattern rules to each line.
+func (r *Redactor) Copy(dst io.Writer, src io.Reader) (int64, error) {
+	if len(r.Rules.Patterns) == 0 {
+		return io.Copy(dst, src)
+	}
+
+	var written int64
+	reader := bufio.NewReader(src)
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			n, werr := io.WriteString(dst, r.RedactString(line))
+			written += int64(n)
+			if werr != nil {
+				return written, werr
+			}
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+func isSecret(obj map[string]interface{}) bool {
+	return obj["apiVersion"] == "v1" && obj["kind"] == "Secret"
+}
+
+// redactSecret replaces values of data and stringData, keys, type and metadata
+// are kept. The last applied configuration also contains the data and is
+// replaced as a whole.
+func (r *Redactor) redactSecret(obj map[string]interface{}) {
+	if r.Secrets == SecretModeNone {
+		return
+	}
+
+	for _, field := range []string{"data", "stringData"} {
+		data, ok := obj[field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for key, value := range data {
+			s, _ := value.(string)
+			data[key] = r.placeholder(s, field == "data")
+		}
+		r.count(secretRuleName, len(data))
+	}
+
+	metadata, ok := obj["metadata"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	annotations, ok := metadata["annotations"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	if value, ok := annotations[lastAppliedConfigAnnotation].(string); ok {
+		annotations[lastAppliedConfigAnnotation] = r.placeholder(value, false)
+		r.count(secretRuleName, 1)
+	}
+}
+
+func (r *Redactor) placeholder(value string, encoded bool) string {
+	if r.Secrets == SecretModeMask {
+		return maskPlaceholder
+	}
+
+	raw := []byte(value)
+	if encoded {
+		if decoded, err := base64.StdEncoding.DecodeString(value); err == nil {
+			raw = decoded
+		}
+	}
+	sum := sha256.Sum256(raw)
+	return fmt.Sprintf("%s(sha256:%x,len:%d)", maskPlaceholder, sum[:8], len(raw))
+}
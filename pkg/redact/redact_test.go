@@ -0,0 +1,174 @@
+package redact
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+const sampleLastApplied = `{"apiVersion":"v1","kind":"Secret","data":{"password":"c2VjcmV0"}}`
+
+// newSampleSecret returns a Secret as decoded from a discovered list, with
+// "secret" in both data (base64-encoded) and stringData
+func newSampleSecret() map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"type":       "Opaque",
+		"metadata": map[string]interface{}{
+			"name":      "db",
+			"namespace": "default",
+			"labels": map[string]interface{}{
+				"app": "db",
+			},
+			"annotations": map[string]interface{}{
+				lastAppliedConfigAnnotation: sampleLastApplied,
+				"owner":                     "team-a",
+			},
+		},
+		"data": map[string]interface{}{
+			"password": "c2VjcmV0",
+		},
+		"stringData": map[string]interface{}{
+			"username": "secret",
+		},
+	}
+}
+
+func hashPlaceholder(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return fmt.Sprintf("%s(sha256:%x,len:%d)", maskPlaceholder, sum[:8], len(raw))
+}
+
+func TestRedactSecret(t *testing.T) {
+	tests := []struct {
+		name           string
+		mode           SecretMode
+		wantData       string
+		wantStringData string
+		wantLastApply  string
+		wantCount      int
+	}{
+		{
+			name:           "hash",
+			mode:           SecretModeHash,
+			wantData:       hashPlaceholder("secret"),
+			wantStringData: hashPlaceholder("secret"),
+			wantLastApply:  hashPlaceholder(sampleLastApplied),
+			wantCount:      3,
+		},
+		{
+			name:           "mask",
+			mode:           SecretModeMask,
+			wantData:       maskPlaceholder,
+			wantStringData: maskPlaceholder,
+			wantLastApply:  maskPlaceholder,
+			wantCount:      3,
+		},
+		{
+			name:           "none",
+			mode:           SecretModeNone,
+			wantData:       "c2VjcmV0",
+			wantStringData: "secret",
+			wantLastApply:  sampleLastApplied,
+			wantCount:      0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRedactor(tt.mode, nil)
+			obj := newSampleSecret()
+			r.Redact(map[string]interface{}{
+				"items": []interface{}{obj},
+			})
+
+			data := obj["data"].(map[string]interface{})
+			if got := data["password"]; got != tt.wantData {
+				t.Errorf("data.password = %v, want %v", got, tt.wantData)
+			}
+			stringData := obj["stringData"].(map[string]interface{})
+			if got := stringData["username"]; got != tt.wantStringData {
+				t.Errorf("stringData.username = %v, want %v", got, tt.wantStringData)
+			}
+
+			metadata := obj["metadata"].(map[string]interface{})
+			annotations := metadata["annotations"].(map[string]interface{})
+			if got := annotations[lastAppliedConfigAnnotation]; got != tt.wantLastApply {
+				t.Errorf("last applied configuration = %v, want %v", got, tt.wantLastApply)
+			}
+
+			// keys, type and the rest of the metadata are kept
+			if _, ok := data["password"]; !ok || len(data) != 1 {
+				t.Errorf("data keys = %v, want [password]", data)
+			}
+			if _, ok := stringData["username"]; !ok || len(stringData) != 1 {
+				t.Errorf("stringData keys = %v, want [username]", stringData)
+			}
+			if obj["type"] != "Opaque" {
+				t.Errorf("type = %v, want Opaque", obj["type"])
+			}
+			if metadata["name"] != "db" || metadata["namespace"] != "default" {
+				t.Errorf("metadata = %v, want name and namespace kept", metadata)
+			}
+			if !reflect.DeepEqual(metadata["labels"], map[string]interface{}{"app": "db"}) {
+				t.Errorf("labels = %v, want kept", metadata["labels"])
+			}
+			if annotations["owner"] != "team-a" {
+				t.Errorf("annotations = %v, want other annotations kept", annotations)
+			}
+
+			report := r.Report()
+			if got := report.Rules[0].Count; got != tt.wantCount {
+				t.Errorf("redaction count = %d, want %d", got, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestRedactSecretOnlySecrets(t *testing.T) {
+	r := NewRedactor(SecretModeMask, nil)
+	obj := newSampleSecret()
+	obj["kind"] = "ConfigMap"
+	r.RedactObject(obj)
+
+	data := obj["data"].(map[string]interface{})
+	if got := data["password"]; got != "c2VjcmV0" {
+		t.Errorf("data.password of a ConfigMap = %v, want it kept", got)
+	}
+}
+
+func TestRedactSecretHashIsStable(t *testing.T) {
+	r := NewRedactor(SecretModeHash, nil)
+	a, b := newSampleSecret(), newSampleSecret()
+	r.RedactObject(a)
+	r.RedactObject(b)
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("hashes of equal secrets differ: %v, %v", a, b)
+	}
+}
+
+func TestParseSecretMode(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    SecretMode
+		wantErr bool
+	}{
+		{in: "", want: SecretModeHash},
+		{in: "hash", want: SecretModeHash},
+		{in: "mask", want: SecretModeMask},
+		{in: "none", want: SecretModeNone},
+		{in: "plain", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSecretMode(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSecretMode(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSecretMode(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
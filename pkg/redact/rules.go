@@ -0,0 +1,143 @@
+package redact
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	ruleTypeSecret  = "secret"
+	ruleTypeField   = "field"
+	ruleTypePattern = "pattern"
+)
+
+// Rules is the content of a redaction rules file, e.g.,
+//
+//	patterns:
+//	- name: passwords
+//	  regex: '(?i)(password[=:]\s*)\S+'
+//	  replacement: '${1}REDACTED'
+//	fields:
+//	- name: env-values
+//	  kinds: [Pod]
+//	  paths: ['spec.containers[*].env[*].value']
+type Rules struct {
+	// Patterns are applied to every line of pod logs and to every string
+	// value of objects.
+	Patterns []PatternRule `yaml:"patterns"`
+	// Fields replace the selected fields of objects as a whole.
+	Fields []FieldRule `yaml:"fields"`
+}
+
+type PatternRule struct {
+	Name  string `yaml:"name"`
+	Regex string `yaml:"regex"`
+	// Replacement may refer to submatches, e.g., ${1}. Defaults to REDACTED.
+	Replacement string `yaml:"replacement"`
+
+	re *regexp.Regexp
+}
+
+type FieldRule struct {
+	Name string `yaml:"name"`
+	// Kinds limits the rule to objects of these kinds. The rule applies to all
+	// objects if empty.
+	Kinds []string `yaml:"kinds"`
+	Paths []string `yaml:"paths"`
+	// Replacement defaults to REDACTED.
+	Replacement string `yaml:"replacement"`
+
+	paths [][]segment
+}
+
+// LoadRules reads and compiles a redaction rules file
+func LoadRules(file string) (*Rules, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	rules := &Rules{}
+	if err := yaml.UnmarshalStrict(data, rules); err != nil {
+		return nil, fmt.Errorf("fail to parse redaction rules %s: %v", file, err)
+	}
+	if err := rules.compile(); err != nil {
+		return nil, fmt.Errorf("invalid redaction rules %s: %v", file, err)
+	}
+	return rules, nil
+}
+
+func (r *Rules) compile() error {
+	names := make(map[string]struct{})
+	checkName := func(name string) error {
+		if name == "" {
+			return fmt.Errorf("rule name is not specified")
+		}
+		if _, ok := names[name]; ok {
+			return fmt.Errorf("duplicated rule name %s", name)
+		}
+		names[name] = struct{}{}
+		return nil
+	}
+
+	for i := range r.Patterns {
+		rule := &r.Patterns[i]
+		if err := checkName(rule.Name); err != nil {
+			return err
+		}
+		re, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return fmt.Errorf("rule %s: %v", rule.Name, err)
+		}
+		rule.re = re
+		if rule.Replacement == "" {
+			rule.Replacement = maskPlaceholder
+		}
+	}
+
+	for i := range r.Fields {
+		rule := &r.Fields[i]
+		if err := checkName(rule.Name); err != nil {
+			return err
+		}
+		if len(rule.Paths) == 0 {
+			return fmt.Errorf("rule %s: paths are not specified", rule.Name)
+		}
+		for _, p := range rule.Paths {
+			segments, err := parsePath(p)
+			if err != nil {
+				return fmt.Errorf("rule %s: %v", rule.Name, err)
+			}
+			rule.paths = append(rule.paths, segments)
+		}
+		if rule.Replacement == "" {
+			rule.Replacement = maskPlaceholder
+		}
+	}
+	return nil
+}
+
+func (f *FieldRule) matchKind(obj map[string]interface{}) bool {
+	if len(f.Kinds) == 0 {
+		return true
+	}
+	for _, kind := range f.Kinds {
+		if obj["kind"] == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Report counts how often each rule is applied
+type Report struct {
+	Rules []RuleReport `yaml:"rules"`
+}
+
+type RuleReport struct {
+	Name  string `yaml:"name"`
+	Type  string `yaml:"type"`
+	Count int    `yaml:"count"`
+}
@@ -19,6 +19,10 @@ const (
 
 	SupportBundleManager = "support-bundle-manager"
 	SupportBundleAgent   = "support-bundle-agent"
+
+	// AgentTokenKey is the key of the agent secret that holds the token agents
+	// authenticate their uploads with
+	AgentTokenKey = "token"
 )
 
 type ManagerPhase string
@@ -31,6 +35,15 @@ const (
 	ManagerPhaseDone          = ManagerPhase("done")
 )
 
+type NodeBundlePhase string
+
+const (
+	NodeBundlePhasePending  = NodeBundlePhase("pending")
+	NodeBundlePhaseUploaded = NodeBundlePhase("uploaded")
+	NodeBundlePhaseFailed   = NodeBundlePhase("failed")
+	NodeBundlePhaseSkipped  = NodeBundlePhase("skipped")
+)
+
 type ManagerStatus struct {
 	Phase        ManagerPhase
 	Error        bool
@@ -38,6 +51,35 @@ type ManagerStatus struct {
 	Progress     int
 	FileName     string
 	FileSize     int64
+	Nodes        []NodeStatus
+}
+
+// NodeStatus is the collection status of a node bundle
+type NodeStatus struct {
+	Name string
+	// AgentPod is the name of the agent pod on the node
+	AgentPod string
+	// AgentPodState is the pod phase, with the reason if the pod is stuck,
+	// e.g., Pending (ImagePullBackOff: ...)
+	AgentPodState string
+	Phase         NodeBundlePhase
+	UploadedAt    metav1.Time
+	FileSize      int64
+	FailureReason string
+	// Partial is set if the node bundle is collected through the kubelet
+	// proxy instead of the agent
+	Partial bool
+}
+
+// NodeFailure is reported by an agent that fails to produce a node bundle
+type NodeFailure struct {
+	// Step is the collection step that failed, e.g., upload or command dmesg
+	Step    string `json:"step"`
+	Message string `json:"message"`
+	// Stderr is the tail of the stderr of a failed command
+	Stderr string `json:"stderr,omitempty"`
+	// ExitCode is the exit code of a failed command
+	ExitCode *int `json:"exitCode,omitempty"`
 }
 
 type SupportBundle struct {
@@ -6,6 +6,13 @@ import (
 	"time"
 )
 
+func EnvGetString(key string, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
 func EnvGetBool(key string, defaultValue bool) bool {
 	if parsed, err := strconv.ParseBool(os.Getenv(key)); err == nil {
 		return parsed
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"io"
+	"os"
+)
+
+// TailFile keeps the last size bytes written to it in a file, or everything
+// if size is zero. The file is written as a ring buffer, so a large stream,
+// e.g., the output of journalctl or a kubelet log, never takes more than size
+// bytes on disk. The ring is put in order when the file is closed.
+type TailFile struct {
+	f    *os.File
+	path string
+	size int64
+	// offset is where the next write goes in the ring, once the ring is
+	// full it's also where the oldest byte is
+	offset  int64
+	full    bool
+	written int64
+	closed  bool
+}
+
+func NewTailFile(path string, size int64) (*TailFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &TailFile{f: f, path: path, size: size}, nil
+}
+
+func (t *TailFile) Write(p []byte) (int, error) {
+	n := len(p)
+	t.written += int64(n)
+	if t.size <= 0 {
+		return t.f.Write(p)
+	}
+	if int64(len(p)) >= t.size {
+		// p replaces the whole ring
+		if _, err := t.f.WriteAt(p[int64(len(p))-t.size:], 0); err != nil {
+			return 0, err
+		}
+		t.offset, t.full = 0, true
+		return n, nil
+	}
+
+	head := p
+	if room := t.size - t.offset; int64(len(p)) > room {
+		head = p[:room]
+	}
+	if _, err := t.f.WriteAt(head, t.offset); err != nil {
+		return 0, err
+	}
+	if rest := p[len(head):]; len(rest) > 0 {
+		if _, err := t.f.WriteAt(rest, 0); err != nil {
+			return 0, err
+		}
+	}
+	if t.offset+int64(len(p)) >= t.size {
+		t.full = true
+	}
+	t.offset = (t.offset + int64(len(p))) % t.size
+	return n, nil
+}
+
+// Truncated returns whether the beginning of the output is dropped
+func (t *TailFile) Truncated() bool {
+	return t.size > 0 && t.written > t.size
+}
+
+// Close puts the ring in order and closes the file. It may be called more
+// than once.
+func (t *TailFile) Close() error {
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	if !t.full || t.offset == 0 {
+		return t.f.Close()
+	}
+	defer t.f.Close()
+
+	tmp, err := os.Create(t.path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	// the oldest bytes are after the offset
+	r := io.MultiReader(io.NewSectionReader(t.f, t.offset, t.size-t.offset), io.NewSectionReader(t.f, 0, t.offset))
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), t.path)
+}
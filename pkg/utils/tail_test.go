@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTailFile(t *testing.T) {
+	tests := []struct {
+		name          string
+		size          int64
+		writes        []string
+		want          string
+		wantTruncated bool
+	}{
+		{name: "no limit", size: 0, writes: []string{"abc", "def"}, want: "abcdef"},
+		{name: "under the limit", size: 8, writes: []string{"abc", "def"}, want: "abcdef"},
+		{name: "exactly the limit", size: 6, writes: []string{"abc", "def"}, want: "abcdef"},
+		{name: "wraps", size: 4, writes: []string{"abc", "def"}, want: "cdef", wantTruncated: true},
+		{name: "wraps several times", size: 4, writes: []string{"ab", "cde", "fgh", "i"}, want: "fghi", wantTruncated: true},
+		{name: "write larger than the limit", size: 4, writes: []string{"ab", "cdefghij"}, want: "ghij", wantTruncated: true},
+		{name: "write after a large write", size: 4, writes: []string{"abcdefgh", "ij"}, want: "ghij", wantTruncated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "tail")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, "out.log")
+			f, err := NewTailFile(path, tt.size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range tt.writes {
+				if n, err := f.Write([]byte(w)); err != nil || n != len(w) {
+					t.Fatalf("Write(%q) = %d, %v", w, n, err)
+				}
+				// the output on disk never exceeds the limit
+				if info, err := os.Stat(path); err != nil || (tt.size > 0 && info.Size() > tt.size) {
+					t.Fatalf("output on disk is larger than %d bytes: %v", tt.size, err)
+				}
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatalf("second Close() = %v", err)
+			}
+
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("output = %q, want %q", b, tt.want)
+			}
+			if f.Truncated() != tt.wantTruncated {
+				t.Errorf("Truncated() = %v, want %v", f.Truncated(), tt.wantTruncated)
+			}
+			if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+				t.Errorf("temporary files are left: %v", files)
+			}
+		})
+	}
+}